@@ -0,0 +1,225 @@
+/*
+	Package analysis splits a flight into thermalling (circling) phases and glide phases. A fix is
+	considered to be circling when the average turn rate in a window around it is above minTurnRate,
+	and circling phases shorter than minThermalDuration are treated as part of the surrounding glides.
+*/
+
+package analysis
+
+import (
+	"math"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/mdb"
+)
+
+const (
+	// The average turn rate in degrees per second above which the glider is considered to be circling
+	minTurnRate = 6.0
+	// The time window used to calculate the average turn rate
+	turnRateWindow = 20 * time.Second
+	// Circling phases shorter than this are not considered to be thermals
+	minThermalDuration = 30 * time.Second
+	// Straight phases shorter than this between two thermals are part of the thermal (e.g. when centering)
+	minGlideDuration = 20 * time.Second
+)
+
+// Thermal is a thermalling (circling) phase of a flight
+// Altitudes are in metres, the average climb in m/s and TurnDirection is either "left" or "right"
+type Thermal struct {
+	EntryTime     time.Time `json:"entry_time"`
+	ExitTime      time.Time `json:"exit_time"`
+	Duration      int64     `json:"duration"`
+	AltitudeGain  int64     `json:"altitude_gain"`
+	AvgClimb      float64   `json:"avg_climb"`
+	TurnDirection string    `json:"turn_direction"`
+}
+
+// Glide is a straight flight phase between thermals
+// The distance is in km along the flown path, the speed in km/h, and GlideRatio is the distance
+// flown per metre of altitude lost. GlideRatio is nil if no altitude was lost during the glide
+type Glide struct {
+	StartTime    time.Time `json:"start_time"`
+	EndTime      time.Time `json:"end_time"`
+	Duration     int64     `json:"duration"`
+	Distance     float64   `json:"distance"`
+	AltitudeLoss int64     `json:"altitude_loss"`
+	GlideRatio   *float64  `json:"glide_ratio"`
+	AvgSpeed     float64   `json:"avg_speed"`
+}
+
+// Analysis contains the thermals and glides of a flight, in chronological order
+type Analysis struct {
+	Thermals []Thermal `json:"thermals"`
+	Glides   []Glide   `json:"glides"`
+}
+
+// A phase of the flight, from point start to point end (inclusive)
+type phase struct {
+	circling   bool
+	start, end int
+}
+
+// Analyse splits the flight between takeoff and landing into thermals and glides
+func Analyse(points []mdb.Point, takeoff, landing time.Time) *Analysis {
+	analysis := &Analysis{
+		Thermals: make([]Thermal, 0),
+		Glides:   make([]Glide, 0)}
+
+	// Only use the valid fixes while flying
+	flight := make([]mdb.Point, 0, len(points))
+	for _, p := range mdb.ValidPoints(points) {
+		if !p.Time.Before(takeoff) && !p.Time.After(landing) {
+			flight = append(flight, p)
+		}
+	}
+	if len(flight) < 2 {
+		return analysis
+	}
+
+	alt := mdb.VarioAltitude(flight)
+	turns := headingChanges(flight)
+	for _, ph := range findPhases(flight, turns) {
+		if ph.circling {
+			analysis.Thermals = append(analysis.Thermals, makeThermal(flight, turns, ph, alt))
+		} else {
+			analysis.Glides = append(analysis.Glides, makeGlide(flight, ph, alt))
+		}
+	}
+	return analysis
+}
+
+// Returns the heading change in degrees at each point, positive is a right (clockwise) turn
+func headingChanges(points []mdb.Point) []float64 {
+	turns := make([]float64, len(points))
+	for i := 1; i < len(points)-1; i++ {
+		d := bearing(points[i], points[i+1]) - bearing(points[i-1], points[i])
+		for d > 180 {
+			d -= 360
+		}
+		for d < -180 {
+			d += 360
+		}
+		turns[i] = d
+	}
+	return turns
+}
+
+// Finds the circling and straight phases of the flight
+func findPhases(points []mdb.Point, turns []float64) []phase {
+	// Decide for each point if the glider is circling, using the average turn rate in a window around the point
+	circling := make([]bool, len(points))
+	lo, hi := 0, 0
+	sum := 0.0
+	for i := range points {
+		for hi < len(points) && points[hi].Time.Sub(points[i].Time) <= turnRateWindow/2 {
+			sum += turns[hi]
+			hi++
+		}
+		for points[i].Time.Sub(points[lo].Time) > turnRateWindow/2 {
+			sum -= turns[lo]
+			lo++
+		}
+		dt := points[hi-1].Time.Sub(points[lo].Time).Seconds()
+		circling[i] = dt > 0 && math.Abs(sum)/dt >= minTurnRate
+	}
+
+	// Group the points into phases, circling phases that are too short become part of the glides
+	phases := make([]phase, 0)
+	for i := 0; i < len(points); {
+		j := i
+		for j+1 < len(points) && circling[j+1] == circling[i] {
+			j++
+		}
+		ph := phase{circling: circling[i], start: i, end: j}
+		if ph.circling && points[j].Time.Sub(points[i].Time) < minThermalDuration {
+			ph.circling = false
+		}
+
+		// Merge with the previous phase if it is of the same kind
+		if n := len(phases); n > 0 && phases[n-1].circling == ph.circling {
+			phases[n-1].end = ph.end
+		} else {
+			phases = append(phases, ph)
+		}
+		i = j + 1
+	}
+
+	// Merge short straight phases between two thermals into one thermal
+	merged := make([]phase, 0, len(phases))
+	for i := 0; i < len(phases); i++ {
+		ph := phases[i]
+		n := len(merged)
+		if !ph.circling && n > 0 && merged[n-1].circling && i+1 < len(phases) &&
+			points[ph.end].Time.Sub(points[ph.start].Time) < minGlideDuration {
+			merged[n-1].end = phases[i+1].end
+			i++
+			continue
+		}
+		merged = append(merged, ph)
+	}
+
+	// Phases share their boundary points, so that glides start where thermals end
+	for i := 1; i < len(merged); i++ {
+		merged[i].start = merged[i-1].end
+	}
+	return merged
+}
+
+func makeThermal(points []mdb.Point, turns []float64, ph phase, alt func(mdb.Point) int64) Thermal {
+	entry, exit := points[ph.start], points[ph.end]
+	thermal := Thermal{
+		EntryTime:     entry.Time,
+		ExitTime:      exit.Time,
+		Duration:      int64(exit.Time.Sub(entry.Time).Seconds()),
+		AltitudeGain:  alt(exit) - alt(entry),
+		TurnDirection: "right"}
+
+	if thermal.Duration > 0 {
+		thermal.AvgClimb = mdb.Round2(float64(thermal.AltitudeGain) / float64(thermal.Duration))
+	}
+
+	turned := 0.0
+	for i := ph.start; i <= ph.end; i++ {
+		turned += turns[i]
+	}
+	if turned < 0 {
+		thermal.TurnDirection = "left"
+	}
+	return thermal
+}
+
+func makeGlide(points []mdb.Point, ph phase, alt func(mdb.Point) int64) Glide {
+	start, end := points[ph.start], points[ph.end]
+	glide := Glide{
+		StartTime:    start.Time,
+		EndTime:      end.Time,
+		Duration:     int64(end.Time.Sub(start.Time).Seconds()),
+		AltitudeLoss: alt(start) - alt(end)}
+
+	dist := 0.0
+	for i := ph.start; i < ph.end; i++ {
+		dist += mdb.Distance(points[i], points[i+1])
+	}
+	glide.Distance = mdb.Round2(dist)
+
+	if glide.Duration > 0 {
+		glide.AvgSpeed = mdb.Round2(dist / float64(glide.Duration) * 3600)
+	}
+	if glide.AltitudeLoss > 0 {
+		ratio := mdb.Round2(dist * 1000 / float64(glide.AltitudeLoss))
+		glide.GlideRatio = &ratio
+	}
+	return glide
+}
+
+// Returns the initial bearing from point a to point b, in degrees clockwise from north
+func bearing(a, b mdb.Point) float64 {
+	const rad = math.Pi / 180
+	lat1, lat2 := a.Lat*rad, b.Lat*rad
+	dLng := (b.Lng - a.Lng) * rad
+
+	y := math.Sin(dLng) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLng)
+	return math.Atan2(y, x) / rad
+}
@@ -9,8 +9,6 @@ import (
 
 	"github.com/haakonleg/imt2681-assig2/mdb"
 	"github.com/haakonleg/imt2681-assig2/util"
-	"github.com/mongodb/mongo-go-driver/bson"
-	"github.com/mongodb/mongo-go-driver/mongo/findopt"
 )
 
 const (
@@ -23,7 +21,7 @@ type SlackWebhook struct {
 	Text     string `json:"text"`
 }
 
-func Start(db *mdb.Database, whURL string) {
+func Start(db mdb.Storage, whURL string) {
 	// Infinite loop
 	for {
 		time.Sleep(intervalMin * time.Minute)
@@ -33,16 +31,10 @@ func Start(db *mdb.Database, whURL string) {
 }
 
 // Check whether new tracks have been added since last check
-func checkNewTracks(db *mdb.Database, whURL string) {
+func checkNewTracks(db mdb.Storage, whURL string) {
 	// Check if tracks have been added in the last 10 minutes
-	findopts := []findopt.Find{
-		findopt.Projection(bson.NewDocument(bson.EC.Int64("ts", 1)))}
-	filter := bson.NewDocument(
-		bson.EC.SubDocumentFromElements("ts",
-			bson.EC.Int64("$gt", util.NowMilli()-intervalMin*60000)))
-
-	tracks := make([]*mdb.Track, 0)
-	if err := db.Find(mdb.TRACKS, filter, findopts, &tracks); err != nil {
+	tracks, err := db.GetTracksAfter(util.NowMilli()-intervalMin*60000, 0)
+	if err != nil {
 		fmt.Println(err)
 		return
 	}
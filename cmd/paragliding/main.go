@@ -3,7 +3,9 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
 
+	"github.com/haakonleg/imt2681-assig2/mdb"
 	"github.com/haakonleg/imt2681-assig2/paragliding"
 )
 
@@ -21,16 +23,22 @@ func main() {
 		port = defaultPort
 	}
 
-	mongoURL := os.Getenv("PARAGLIDING_MONGO")
-	if len(mongoURL) == 0 {
-		log.Fatal("PARAGLIDING_MONGO environment variable is not set (put mongodb url in here)")
-	}
-
 	// Configure and start the API
 	app := paragliding.App{
-		MongoURL:    mongoURL,
 		ListenPort:  port,
 		DBName:      dbName,
 		TickerLimit: 5}
+
+	// Use in-memory storage if no mongoDB url is set
+	app.MongoURL = os.Getenv("PARAGLIDING_MONGO")
+	if len(app.MongoURL) == 0 {
+		log.Println("PARAGLIDING_MONGO environment variable is not set, using in-memory storage")
+		app.Storage = mdb.NewMemoryDatabase()
+	}
+
+	// Allow browsers on other origins to use the API, if configured
+	if origins := os.Getenv("PARAGLIDING_CORS_ORIGINS"); len(origins) > 0 {
+		app.CORSOrigins = strings.Split(origins, ",")
+	}
 	app.StartServer()
 }
@@ -0,0 +1,98 @@
+/*
+	Package geo implements distance calculations between coordinates on the earth, using either a
+	spherical model (haversine) or the WGS84 ellipsoid (Vincenty), and the distance models which can be
+	used to calculate the length of a track.
+*/
+
+package geo
+
+import "math"
+
+const (
+	// EarthRadius is the mean earth radius in metres, used by the spherical model
+	EarthRadius = 6371000.0
+
+	// WGS84 ellipsoid parameters
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+	wgs84B = wgs84A * (1 - wgs84F)
+
+	rad = math.Pi / 180
+)
+
+// LatLng is a coordinate, the latitude and longitude are in degrees
+type LatLng struct {
+	Lat float64
+	Lng float64
+}
+
+// DistanceFunc is the function template for distance formulas, the distance is in metres
+type DistanceFunc func(a, b LatLng) float64
+
+// Haversine calculates the distance between two coordinates on the earth sphere, in metres
+func Haversine(a, b LatLng) float64 {
+	lat1, lat2 := a.Lat*rad, b.Lat*rad
+	dLat := lat2 - lat1
+	dLng := (b.Lng - a.Lng) * rad
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*
+			math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return EarthRadius * 2 * math.Atan2(math.Sqrt(h), math.Sqrt(1-h))
+}
+
+// Vincenty calculates the distance between two coordinates on the WGS84 ellipsoid, in metres, using
+// the inverse formula by Vincenty. If the formula does not converge (nearly antipodal points), the
+// haversine distance is returned instead
+func Vincenty(a, b LatLng) float64 {
+	L := (b.Lng - a.Lng) * rad
+	U1 := math.Atan((1 - wgs84F) * math.Tan(a.Lat*rad))
+	U2 := math.Atan((1 - wgs84F) * math.Tan(b.Lat*rad))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	lambda := L
+	for i := 0; i < 200; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma := math.Sqrt((cosU2*sinLambda)*(cosU2*sinLambda) +
+			(cosU1*sinU2-sinU1*cosU2*cosLambda)*(cosU1*sinU2-sinU1*cosU2*cosLambda))
+		if sinSigma == 0 {
+			// Coincident points
+			return 0
+		}
+		cosSigma := sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma := math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha := 1 - sinAlpha*sinAlpha
+		cos2SigmaM := 0.0
+		if cosSqAlpha != 0 {
+			// Not on the equator
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+		C := wgs84F / 16 * cosSqAlpha * (4 + wgs84F*(4-3*cosSqAlpha))
+
+		prev := lambda
+		lambda = L + (1-C)*wgs84F*sinAlpha*
+			(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+
+		if math.Abs(lambda-prev) < 1e-12 {
+			uSq := cosSqAlpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+			A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+			B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+			deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+				B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+			return wgs84B * A * (sigma - deltaSigma)
+		}
+	}
+	return Haversine(a, b)
+}
+
+// PathLength returns the sum of the distances between each consecutive coordinate, in metres
+func PathLength(points []LatLng, dist DistanceFunc) float64 {
+	d := 0.0
+	for i := 0; i < len(points)-1; i++ {
+		d += dist(points[i], points[i+1])
+	}
+	return d
+}
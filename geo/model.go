@@ -0,0 +1,51 @@
+package geo
+
+// Model is a distance model which is used to calculate the length of a track
+type Model string
+
+const (
+	// ModelHaversine is the sum of the distances between each fix on the earth sphere
+	ModelHaversine Model = "haversine"
+	// ModelVincenty is the sum of the distances between each fix on the WGS84 ellipsoid
+	ModelVincenty Model = "vincenty"
+	// ModelFreeDistance is the optimised free distance through up to 3 turnpoints
+	ModelFreeDistance Model = "free_distance"
+	// ModelFAITriangle is the perimeter of the largest FAI triangle
+	ModelFAITriangle Model = "fai_triangle"
+
+	// DefaultModel is the distance model used if no model is chosen
+	DefaultModel = ModelHaversine
+)
+
+// Models is a list of all the supported distance models
+var Models = []Model{ModelHaversine, ModelVincenty, ModelFreeDistance, ModelFAITriangle}
+
+// ParseModel returns the distance model with the specified name, and a bool indicating if it exists
+// An empty name gives the default model
+func ParseModel(name string) (Model, bool) {
+	if len(name) == 0 {
+		return DefaultModel, true
+	}
+	for _, m := range Models {
+		if string(m) == name {
+			return m, true
+		}
+	}
+	return "", false
+}
+
+// TrackLength calculates the length of a track in metres with the specified distance model
+func TrackLength(points []LatLng, model Model) float64 {
+	switch model {
+	case ModelVincenty:
+		return PathLength(points, Vincenty)
+	case ModelFreeDistance:
+		d, _ := FreeDistance(points, 3, Haversine)
+		return d
+	case ModelFAITriangle:
+		d, _ := Triangle(points, true, Haversine)
+		return d
+	default:
+		return PathLength(points, Haversine)
+	}
+}
@@ -0,0 +1,168 @@
+package geo
+
+const (
+	// The maximum amount of points used by the optimisers, longer tracks are decimated
+	maxOptimizePoints = 400
+	// The minimum share of the total distance of each leg in an FAI triangle
+	faiMinLeg = 0.28
+)
+
+// FreeDistance finds the longest distance from a start point, through up to the specified amount of
+// turnpoints, to a finish point, where the points are visited in order. It returns the distance in
+// metres and the indices of the start point, turnpoints and finish point
+func FreeDistance(points []LatLng, turnpoints int, dist DistanceFunc) (float64, []int) {
+	if len(points) < 2 {
+		return 0, nil
+	}
+	idx := decimate(len(points))
+	d := distanceMatrix(points, idx, dist)
+	n := len(idx)
+	legs := turnpoints + 1
+
+	// best[l][j] is the longest distance with l legs ending in point j, and from[l][j] the previous point
+	best := make([][]float64, legs+1)
+	from := make([][]int, legs+1)
+	best[0] = make([]float64, n)
+	for l := 1; l <= legs; l++ {
+		best[l] = make([]float64, n)
+		from[l] = make([]int, n)
+		for j := 0; j < n; j++ {
+			for i := 0; i <= j; i++ {
+				if cand := best[l-1][i] + d[i][j]; cand >= best[l][j] {
+					best[l][j] = cand
+					from[l][j] = i
+				}
+			}
+		}
+	}
+
+	end := 0
+	for j := 0; j < n; j++ {
+		if best[legs][j] > best[legs][end] {
+			end = j
+		}
+	}
+
+	// Walk back through the legs, skipping legs of zero length (fewer turnpoints)
+	path := []int{idx[end]}
+	for l, j := legs, end; l > 0; l-- {
+		i := from[l][j]
+		if i != j {
+			path = append([]int{idx[i]}, path...)
+		}
+		j = i
+	}
+	return best[legs][end], path
+}
+
+// Triangle finds the triangle with the longest perimeter where the corners are points in the track,
+// visited in order. If fai is true, each leg must be at least 28% of the perimeter (FAI triangle)
+// It returns the perimeter in metres and the indices of the three corners, or 0 and nil if no
+// triangle was found
+func Triangle(points []LatLng, fai bool, dist DistanceFunc) (float64, []int) {
+	perimeter, _, corners := ClosedTriangle(points, fai, -1, dist)
+	return perimeter, corners
+}
+
+// ClosedTriangle finds the triangle with the longest perimeter like Triangle, but the flight must also be
+// closed: there must be a point before the first corner and a point after the last corner which are at
+// most closingRatio times the perimeter apart. A negative closingRatio disables this requirement
+// It returns the perimeter and the closing distance in metres, and the indices of the three corners
+func ClosedTriangle(points []LatLng, fai bool, closingRatio float64, dist DistanceFunc) (float64, float64, []int) {
+	idx := decimate(len(points))
+	d := distanceMatrix(points, idx, dist)
+	n := len(idx)
+
+	// closing[i][k] is the shortest distance between a point before or at i and a point after or at k
+	closing := make([][]float64, n)
+	for i := 0; i < n; i++ {
+		closing[i] = make([]float64, n)
+		for k := n - 1; k >= i; k-- {
+			c := d[i][k]
+			if i > 0 && closing[i-1][k] < c {
+				c = closing[i-1][k]
+			}
+			if k < n-1 && closing[i][k+1] < c {
+				c = closing[i][k+1]
+			}
+			closing[i][k] = c
+		}
+	}
+
+	bestP, bestC := 0.0, 0.0
+	var corners []int
+	for i := 0; i < n; i++ {
+		for k := i + 2; k < n; k++ {
+			if closingRatio >= 0 && closing[i][k] > closingRatio*(d[i][k]+2*maxLeg(d, i, k)) {
+				// Even the largest possible triangle between i and k is not closed
+				continue
+			}
+			for j := i + 1; j < k; j++ {
+				a, b, c := d[i][j], d[j][k], d[k][i]
+				p := a + b + c
+				if p <= bestP {
+					continue
+				}
+				if fai && (a < faiMinLeg*p || b < faiMinLeg*p || c < faiMinLeg*p) {
+					continue
+				}
+				if closingRatio >= 0 && closing[i][k] > closingRatio*p {
+					continue
+				}
+				bestP, bestC = p, closing[i][k]
+				corners = []int{idx[i], idx[j], idx[k]}
+			}
+		}
+	}
+	if closingRatio < 0 {
+		bestC = 0
+	}
+	return bestP, bestC, corners
+}
+
+// Returns the longest distance from point i or k to a point between them, used as an upper bound
+// for the legs of a triangle with the corners i and k
+func maxLeg(d [][]float64, i, k int) float64 {
+	m := 0.0
+	for j := i + 1; j < k; j++ {
+		if d[i][j] > m {
+			m = d[i][j]
+		}
+		if d[j][k] > m {
+			m = d[j][k]
+		}
+	}
+	return m
+}
+
+// Returns the indices of at most maxOptimizePoints points, evenly spread over the track
+func decimate(n int) []int {
+	if n <= maxOptimizePoints {
+		idx := make([]int, n)
+		for i := range idx {
+			idx[i] = i
+		}
+		return idx
+	}
+
+	idx := make([]int, maxOptimizePoints)
+	for i := range idx {
+		idx[i] = i * (n - 1) / (maxOptimizePoints - 1)
+	}
+	return idx
+}
+
+// Calculates the distances between all the selected points
+func distanceMatrix(points []LatLng, idx []int, dist DistanceFunc) [][]float64 {
+	d := make([][]float64, len(idx))
+	for i := range idx {
+		d[i] = make([]float64, len(idx))
+	}
+	for i := range idx {
+		for j := i + 1; j < len(idx); j++ {
+			d[i][j] = dist(points[idx[i]], points[idx[j]])
+			d[j][i] = d[i][j]
+		}
+	}
+	return d
+}
@@ -0,0 +1,116 @@
+package mdb
+
+import (
+	"strings"
+	"time"
+)
+
+// The fields that tracks can be sorted by
+const (
+	SortTimestamp = "ts"
+	SortDate      = "H_date"
+	SortLength    = "track_length"
+)
+
+// dateLayout is the layout of the dates in the H_date field that are compared when filtering by flight date
+const dateLayout = "2006-01-02"
+
+// TrackFilter contains the criteria used when searching for tracks. Empty strings and zero values mean that the
+// field is not filtered on. The dates are inclusive and only the date part is used, TsFrom and TsTo are inclusive
+// timestamps in milliseconds and the lengths are in metres
+// The tracks are sorted by SortField (SortTimestamp if empty), ties are broken by ID. If After is set, only the
+// tracks that come after it in the sort order are returned, which is used for cursor based pagination
+type TrackFilter struct {
+	Pilot     string
+	Glider    string
+	GliderID  string
+	DateFrom  time.Time
+	DateTo    time.Time
+	TsFrom    int64
+	TsTo      int64
+	MinLength float64
+	MaxLength float64
+
+	SortField  string
+	Descending bool
+	Limit      int64
+	After      *Track
+}
+
+// sortField returns the field the tracks are sorted by
+func (f *TrackFilter) sortField() string {
+	if f.SortField == "" {
+		return SortTimestamp
+	}
+	return f.SortField
+}
+
+// dateRange returns the lowest (inclusive) and highest (exclusive) H_date values that match the date filter
+// An empty string means that the date is not limited
+func (f *TrackFilter) dateRange() (string, string) {
+	from, to := "", ""
+	if !f.DateFrom.IsZero() {
+		from = f.DateFrom.Format(dateLayout)
+	}
+	if !f.DateTo.IsZero() {
+		to = f.DateTo.AddDate(0, 0, 1).Format(dateLayout)
+	}
+	return from, to
+}
+
+// matches returns true if the track matches the filter criteria (not including After)
+func (f *TrackFilter) matches(track *Track) bool {
+	from, to := f.dateRange()
+	switch {
+	case f.Pilot != "" && track.Pilot != f.Pilot:
+		return false
+	case f.Glider != "" && track.Glider != f.Glider:
+		return false
+	case f.GliderID != "" && track.GliderID != f.GliderID:
+		return false
+	case from != "" && track.HDate < from:
+		return false
+	case to != "" && track.HDate >= to:
+		return false
+	case f.TsFrom != 0 && track.Ts < f.TsFrom:
+		return false
+	case f.TsTo != 0 && track.Ts > f.TsTo:
+		return false
+	case f.MinLength != 0 && track.TrackLength < f.MinLength:
+		return false
+	case f.MaxLength != 0 && track.TrackLength > f.MaxLength:
+		return false
+	}
+	return true
+}
+
+// compare compares two tracks in the sort order of the filter, and returns -1 if a comes first, 1 if b comes
+// first and 0 if they are the same track
+func (f *TrackFilter) compare(a, b *Track) int {
+	c := 0
+	switch f.sortField() {
+	case SortTimestamp:
+		c = compareFloat(float64(a.Ts), float64(b.Ts))
+	case SortDate:
+		c = strings.Compare(a.HDate, b.HDate)
+	case SortLength:
+		c = compareFloat(a.TrackLength, b.TrackLength)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ID.Hex(), b.ID.Hex())
+	}
+	if f.Descending {
+		return -c
+	}
+	return c
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -5,10 +5,15 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"strconv"
+	"strings"
 
+	"github.com/haakonleg/imt2681-assig2/geo"
 	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
 	"github.com/mongodb/mongo-go-driver/mongo"
 	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/mongoopt"
 )
 
 // An enum of the database collections
@@ -17,6 +22,8 @@ type DatabaseCollection int
 const (
 	TRACKS DatabaseCollection = iota
 	WEBHOOKS
+	POINTS
+	DELIVERIES
 )
 
 // Stringer for databaseCollection type
@@ -26,6 +33,10 @@ func (dc DatabaseCollection) String() string {
 		return "tracks"
 	case WEBHOOKS:
 		return "webhooks"
+	case POINTS:
+		return "points"
+	case DELIVERIES:
+		return "deliveries"
 	}
 	return ""
 }
@@ -48,10 +59,15 @@ func (db *Database) CreateConnection() {
 	db.client = client
 	db.database = db.client.Database(db.DBName)
 	db.createTimestampIndex()
+	db.createPendingTracksIndex()
+	db.createPointsIndex()
+	db.createDeliveriesIndex()
+	db.migrateTrackLengths()
+	db.migrateWebhookTriggers()
 }
 
-// InsertObject inserts an object into the specified collection in the database
-func (db *Database) InsertObject(collection DatabaseCollection, object interface{}) (string, error) {
+// insertObject inserts an object into the specified collection in the database
+func (db *Database) insertObject(collection DatabaseCollection, object interface{}) (string, error) {
 	col := db.database.Collection(collection.String())
 	res, err := col.InsertOne(context.Background(), object)
 	if err != nil {
@@ -61,8 +77,8 @@ func (db *Database) InsertObject(collection DatabaseCollection, object interface
 	return res.InsertedID.(*bson.Element).Value().ObjectID().Hex(), nil
 }
 
-// Find queries documents from the specified collection in the database
-func (db *Database) Find(collection DatabaseCollection, filter interface{}, opts []findopt.Find, results interface{}) error {
+// find queries documents from the specified collection in the database
+func (db *Database) find(collection DatabaseCollection, filter interface{}, opts []findopt.Find, results interface{}) error {
 	col := db.database.Collection(collection.String())
 	cur, err := col.Find(context.Background(), filter, opts...)
 	if err != nil {
@@ -89,14 +105,38 @@ func (db *Database) Find(collection DatabaseCollection, filter interface{}, opts
 			}
 			*resArr = append(*resArr, elem)
 		}
+	case *[]*Delivery:
+		for cur.Next(context.Background()) {
+			elem := new(Delivery)
+			if err := cur.Decode(elem); err != nil {
+				return err
+			}
+			*resArr = append(*resArr, elem)
+		}
+	case *[]*legacyTrack:
+		for cur.Next(context.Background()) {
+			elem := new(legacyTrack)
+			if err := cur.Decode(elem); err != nil {
+				return err
+			}
+			*resArr = append(*resArr, elem)
+		}
+	case *[]*PointChunk:
+		for cur.Next(context.Background()) {
+			elem := new(PointChunk)
+			if err := cur.Decode(elem); err != nil {
+				return err
+			}
+			*resArr = append(*resArr, elem)
+		}
 	default:
 		log.Fatalf("This type is not supported: %s", reflect.TypeOf(resArr))
 	}
 	return nil
 }
 
-// Update updates documents in the specified collection in the database
-func (db *Database) Update(collection DatabaseCollection, filter interface{}, update interface{}) (*mongo.UpdateResult, error) {
+// update updates documents in the specified collection in the database
+func (db *Database) update(collection DatabaseCollection, filter interface{}, update interface{}) (*mongo.UpdateResult, error) {
 	col := db.database.Collection(collection.String())
 	ur, err := col.UpdateMany(context.Background(), filter, update)
 	if err != nil {
@@ -106,10 +146,25 @@ func (db *Database) Update(collection DatabaseCollection, filter interface{}, up
 	return ur, nil
 }
 
-// Count returns the total amount of documents in the specified collection in the database
-func (db *Database) Count(collection DatabaseCollection) (int64, error) {
+// findAndModify atomically updates the first document matching the filter in the specified collection, and
+// decodes the document as it was before the update into result. Returns ErrNotFound if no document matches
+func (db *Database) findAndModify(collection DatabaseCollection, filter interface{}, update interface{}, result interface{}) error {
+	col := db.database.Collection(collection.String())
+	err := col.FindOneAndUpdate(context.Background(), filter, update, findopt.ReturnDocument(mongoopt.Before)).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
+
+// count returns the amount of documents matching the filter in the specified collection in the database
+func (db *Database) count(collection DatabaseCollection, filter interface{}) (int64, error) {
 	col := db.database.Collection(collection.String())
-	cnt, err := col.Count(context.Background(), nil, nil)
+	cnt, err := col.Count(context.Background(), filter)
 	if err != nil {
 		fmt.Println(err)
 		return -1, err
@@ -117,8 +172,8 @@ func (db *Database) Count(collection DatabaseCollection) (int64, error) {
 	return cnt, nil
 }
 
-// Delete removes all the documents in the specified collection from the database
-func (db *Database) Delete(collection DatabaseCollection, filter interface{}) (*mongo.DeleteResult, error) {
+// delete removes the documents matching the filter in the specified collection from the database
+func (db *Database) delete(collection DatabaseCollection, filter interface{}) (*mongo.DeleteResult, error) {
 	col := db.database.Collection(collection.String())
 	dRes, err := col.DeleteMany(context.Background(), filter, nil)
 	if err != nil {
@@ -140,3 +195,582 @@ func (db *Database) createTimestampIndex() {
 		log.Fatal(err)
 	}
 }
+
+// Creates a sparse index on webhooksPending in tracks, which only contains the tracks that the webhook dispatcher
+// has not counted yet, since the field is unset when a track is claimed
+func (db *Database) createPendingTracksIndex() {
+	indexView := db.database.Collection(TRACKS.String()).Indexes()
+
+	indexModel := mongo.IndexModel{
+		Keys:    bson.NewDocument(bson.EC.Int32("webhooksPending", 1)),
+		Options: bson.NewDocument(bson.EC.Boolean("sparse", true))}
+
+	_, err := indexView.CreateOne(context.Background(), indexModel, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Creates an index on the track ID and sequence number of the point chunks, so the points of a track can be retrieved in order
+func (db *Database) createPointsIndex() {
+	indexView := db.database.Collection(POINTS.String()).Indexes()
+
+	indexModel := mongo.IndexModel{
+		Keys: bson.NewDocument(
+			bson.EC.Int32("track_id", 1),
+			bson.EC.Int32("seq", 1))}
+
+	_, err := indexView.CreateOne(context.Background(), indexModel, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Creates indexes on the deliveries collection, for finding the deliveries that are due and the deliveries of a webhook
+func (db *Database) createDeliveriesIndex() {
+	indexView := db.database.Collection(DELIVERIES.String()).Indexes()
+
+	indexModels := []mongo.IndexModel{
+		{Keys: bson.NewDocument(
+			bson.EC.Int32("status", 1),
+			bson.EC.Int32("nextAttempt", 1))},
+		{Keys: bson.NewDocument(
+			bson.EC.Int32("webhookID", 1),
+			bson.EC.Int32("created", -1))}}
+
+	if _, err := indexView.CreateMany(context.Background(), indexModels); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// legacyTrack is a track stored before track_length was stored as a number, when it was a string in km (e.g. "12.34km")
+type legacyTrack struct {
+	ID          objectid.ObjectID `bson:"_id"`
+	TrackLength string            `bson:"track_length"`
+}
+
+// Converts the track_length of tracks stored as a string in km to a number in metres
+// These lengths were calculated with the haversine formula over all the fixes like new tracks, so the distance
+// model is set to haversine, but they were rounded to 10 metres
+func (db *Database) migrateTrackLengths() {
+	filter := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("track_length",
+			bson.EC.String("$type", "string")))
+	findopts := []findopt.Find{
+		findopt.Projection(bson.NewDocument(bson.EC.Int64("track_length", 1)))}
+
+	tracks := make([]*legacyTrack, 0)
+	if err := db.find(TRACKS, filter, findopts, &tracks); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, track := range tracks {
+		km, err := strconv.ParseFloat(strings.TrimSuffix(track.TrackLength, "km"), 64)
+		if err != nil {
+			fmt.Printf("Could not migrate track_length %q of track %s\n", track.TrackLength, track.ID.Hex())
+			continue
+		}
+
+		updateDoc := bson.NewDocument(
+			bson.EC.SubDocumentFromElements("$set",
+				bson.EC.Double("track_length", Round2(km*1000)),
+				bson.EC.String("distance_model", string(geo.ModelHaversine))))
+		if _, err := db.update(TRACKS, bson.NewDocument(bson.EC.ObjectID("_id", track.ID)), updateDoc); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(tracks) > 0 {
+		fmt.Printf("Migrated track_length of %d tracks\n", len(tracks))
+	}
+}
+
+// Sets minTriggerValue and triggerCount to 1 for webhooks registered with a negative minTriggerValue, which would
+// otherwise be fired many times in a row by FireTriggeredWebhook, since it fires until triggerCount is positive
+func (db *Database) migrateWebhookTriggers() {
+	filter := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("minTriggerValue",
+			bson.EC.Int64("$lt", 1)))
+	updateDoc := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$set",
+			bson.EC.Int64("minTriggerValue", 1),
+			bson.EC.Int64("triggerCount", 1)))
+
+	uRes, err := db.update(WEBHOOKS, filter, updateDoc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if uRes.ModifiedCount > 0 {
+		fmt.Printf("Migrated minTriggerValue of %d webhooks\n", uRes.ModifiedCount)
+	}
+}
+
+// Returns a filter matching the document with the specified ID (hex encoded ObjectID)
+func idFilter(id string) (*bson.Document, error) {
+	objectID, err := objectid.FromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return bson.NewDocument(bson.EC.ObjectID("_id", objectID)), nil
+}
+
+// InsertTrack stores a new track in the tracks collection, and its points in chunks in the points collection
+// The points are inserted first, so a track is never visible without its points
+func (db *Database) InsertTrack(track *Track, points []Point) (string, error) {
+	for _, chunk := range chunkPoints(track.ID, points) {
+		if _, err := db.insertObject(POINTS, &chunk); err != nil {
+			return "", err
+		}
+	}
+	return db.insertObject(TRACKS, track)
+}
+
+// GetTrack retrieves a track by its ID
+func (db *Database) GetTrack(id string) (*Track, error) {
+	filter, err := idFilter(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make([]*Track, 0)
+	if err := db.find(TRACKS, filter, nil, &tracks); err != nil {
+		return nil, err
+	}
+	if len(tracks) < 1 {
+		return nil, ErrNotFound
+	}
+	return tracks[0], nil
+}
+
+// GetTrackPoints retrieves the points of a track by the track ID
+func (db *Database) GetTrackPoints(id string) ([]Point, error) {
+	objectID, err := objectid.FromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	filter := bson.NewDocument(bson.EC.ObjectID("track_id", objectID))
+	findopts := []findopt.Find{
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("seq", 1)))}
+
+	chunks := make([]*PointChunk, 0)
+	if err := db.find(POINTS, filter, findopts, &chunks); err != nil {
+		return nil, err
+	}
+	if len(chunks) < 1 {
+		return nil, ErrNotFound
+	}
+
+	points := make([]Point, 0, len(chunks)*PointChunkSize)
+	for _, chunk := range chunks {
+		for _, p := range chunk.Points {
+			p.Time = p.Time.UTC()
+			points = append(points, p)
+		}
+	}
+	return points, nil
+}
+
+// FindTrackIDs returns the IDs of the tracks matching the filter, in the sort order of the filter
+func (db *Database) FindTrackIDs(filter *TrackFilter) ([]string, error) {
+	order := int64(1)
+	if filter.Descending {
+		order = -1
+	}
+
+	// Only get the id, and sort by ID when the sort field is equal so that the cursor is stable
+	findopts := []findopt.Find{
+		findopt.Projection(bson.NewDocument(bson.EC.Int64("_id", 1))),
+		findopt.Sort(bson.NewDocument(
+			bson.EC.Int64(filter.sortField(), order),
+			bson.EC.Int64("_id", order)))}
+	if filter.Limit > 0 {
+		findopts = append(findopts, findopt.Limit(filter.Limit))
+	}
+
+	tracks := make([]*Track, 0)
+	if err := db.find(TRACKS, trackFilterDocument(filter), findopts, &tracks); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(tracks))
+	for _, track := range tracks {
+		ids = append(ids, track.ID.Hex())
+	}
+	return ids, nil
+}
+
+// trackFilterDocument creates the query document for the criteria in a track filter
+func trackFilterDocument(filter *TrackFilter) *bson.Document {
+	doc := bson.NewDocument()
+	if filter.Pilot != "" {
+		doc.Append(bson.EC.String("pilot", filter.Pilot))
+	}
+	if filter.Glider != "" {
+		doc.Append(bson.EC.String("glider", filter.Glider))
+	}
+	if filter.GliderID != "" {
+		doc.Append(bson.EC.String("glider_id", filter.GliderID))
+	}
+
+	// The H_date field starts with the date, so the date range can be compared as strings
+	date := bson.NewDocument()
+	from, to := filter.dateRange()
+	if from != "" {
+		date.Append(bson.EC.String("$gte", from))
+	}
+	if to != "" {
+		date.Append(bson.EC.String("$lt", to))
+	}
+	if date.Len() > 0 {
+		doc.Append(bson.EC.SubDocument("H_date", date))
+	}
+
+	ts := bson.NewDocument()
+	if filter.TsFrom != 0 {
+		ts.Append(bson.EC.Int64("$gte", filter.TsFrom))
+	}
+	if filter.TsTo != 0 {
+		ts.Append(bson.EC.Int64("$lte", filter.TsTo))
+	}
+	if ts.Len() > 0 {
+		doc.Append(bson.EC.SubDocument("ts", ts))
+	}
+
+	length := bson.NewDocument()
+	if filter.MinLength != 0 {
+		length.Append(bson.EC.Double("$gte", filter.MinLength))
+	}
+	if filter.MaxLength != 0 {
+		length.Append(bson.EC.Double("$lte", filter.MaxLength))
+	}
+	if length.Len() > 0 {
+		doc.Append(bson.EC.SubDocument("track_length", length))
+	}
+
+	// Only tracks after the cursor: the sort field is past the cursor, or equal with the ID past the cursor
+	if filter.After != nil {
+		op := "$gt"
+		if filter.Descending {
+			op = "$lt"
+		}
+
+		field := filter.sortField()
+		var value interface{}
+		switch field {
+		case SortTimestamp:
+			value = filter.After.Ts
+		case SortDate:
+			value = filter.After.HDate
+		case SortLength:
+			value = filter.After.TrackLength
+		}
+
+		doc.Append(bson.EC.Array("$or", bson.NewArray(
+			bson.VC.DocumentFromElements(
+				bson.EC.SubDocumentFromElements(field, bson.EC.Interface(op, value))),
+			bson.VC.DocumentFromElements(
+				bson.EC.Interface(field, value),
+				bson.EC.SubDocumentFromElements("_id", bson.EC.ObjectID(op, filter.After.ID))))))
+	}
+	return doc
+}
+
+// GetTracksAfter returns the tracks added after the timestamp ts, oldest first
+func (db *Database) GetTracksAfter(ts int64, limit int64) ([]*Track, error) {
+	filter := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("ts",
+			bson.EC.Int64("$gt", ts)))
+
+	// Sort by timestamp oldest first, limit results if limit is over 0
+	findopts := []findopt.Find{
+		findopt.Sort(bson.NewDocument(bson.EC.Int64("ts", 1)))}
+	if limit > 0 {
+		findopts = append(findopts, findopt.Limit(limit))
+	}
+
+	tracks := make([]*Track, 0)
+	if err := db.find(TRACKS, filter, findopts, &tracks); err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
+
+// LatestTimestamp finds the timestamp of the latest added track
+func (db *Database) LatestTimestamp() (int64, error) {
+	// Sort by timestamp in decsending order, and limit to one result
+	findopts := []findopt.Find{
+		findopt.Sort(bson.NewDocument(bson.EC.Int64("ts", -1))),
+		findopt.Projection(bson.NewDocument(bson.EC.Int64("ts", 1))),
+		findopt.Limit(1)}
+
+	tracks := make([]*Track, 0)
+	if err := db.find(TRACKS, nil, findopts, &tracks); err != nil {
+		return -1, err
+	}
+	if len(tracks) < 1 {
+		return -1, ErrNotFound
+	}
+	return tracks[0].Ts, nil
+}
+
+// CountTracks returns the total amount of tracks in the tracks collection
+func (db *Database) CountTracks() (int64, error) {
+	return db.count(TRACKS, nil)
+}
+
+// DeleteAllTracks removes all documents in the tracks and points collections
+func (db *Database) DeleteAllTracks() (int64, error) {
+	dRes, err := db.delete(TRACKS, nil)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.delete(POINTS, nil); err != nil {
+		return 0, err
+	}
+	return dRes.DeletedCount, nil
+}
+
+// InsertWebhook stores a new webhook in the webhooks collection
+func (db *Database) InsertWebhook(webhook *Webhook) (string, error) {
+	return db.insertObject(WEBHOOKS, webhook)
+}
+
+// GetWebhook retrieves a webhook by its ID
+func (db *Database) GetWebhook(id string) (*Webhook, error) {
+	filter, err := idFilter(id)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]*Webhook, 0)
+	if err := db.find(WEBHOOKS, filter, nil, &webhooks); err != nil {
+		return nil, err
+	}
+	if len(webhooks) < 1 {
+		return nil, ErrNotFound
+	}
+	return webhooks[0], nil
+}
+
+// DeleteWebhook removes a webhook by its ID
+func (db *Database) DeleteWebhook(id string) error {
+	filter, err := idFilter(id)
+	if err != nil {
+		return err
+	}
+
+	dRes, err := db.delete(WEBHOOKS, filter)
+	if err != nil {
+		return err
+	}
+	if dRes.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	webhookID := filter.Lookup("_id").ObjectID()
+	_, err = db.delete(DELIVERIES, bson.NewDocument(bson.EC.ObjectID("webhookID", webhookID)))
+	return err
+}
+
+// ClaimPendingTrack unsets webhooksPending of a track where it is set with a find-and-modify, so that only one
+// server gets the track
+func (db *Database) ClaimPendingTrack() (*Track, error) {
+	filter := bson.NewDocument(bson.EC.Boolean("webhooksPending", true))
+	updateDoc := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$unset",
+			bson.EC.String("webhooksPending", "")))
+
+	track := new(Track)
+	if err := db.findAndModify(TRACKS, filter, updateDoc, track); err != nil {
+		return nil, err
+	}
+	track.WebhooksPending = false
+	return track, nil
+}
+
+// DecrementWebhookTriggers decrements the triggerCount of the webhooks whose filter matches the track by one
+func (db *Database) DecrementWebhookTriggers(track *Track) error {
+	updateDoc := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$inc",
+			bson.EC.Int64("triggerCount", -1)))
+	_, err := db.update(WEBHOOKS, webhookFilterDocument(track), updateDoc)
+	return err
+}
+
+// webhookFilterDocument creates the query for the webhooks whose filter matches the track, see WebhookFilter
+// Webhooks registered before filters were added have no filter field, so missing fields match all tracks
+func webhookFilterDocument(track *Track) *bson.Document {
+	pilots := bson.NewArray(
+		bson.VC.DocumentFromElements(bson.EC.Null("filter.pilots")),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("filter.pilots", bson.EC.Int32("$size", 0))),
+		bson.VC.DocumentFromElements(bson.EC.String("filter.pilots", normalizeName(track.Pilot))))
+	glider := bson.NewArray(
+		bson.VC.DocumentFromElements(bson.EC.Null("filter.glider")),
+		bson.VC.DocumentFromElements(bson.EC.String("filter.glider", "")),
+		bson.VC.DocumentFromElements(bson.EC.String("filter.glider", normalizeName(track.Glider))))
+	distance := bson.NewArray(
+		bson.VC.DocumentFromElements(bson.EC.Null("filter.minDistance")),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("filter.minDistance", bson.EC.Double("$lte", track.TrackLength))))
+
+	return bson.NewDocument(
+		bson.EC.Array("$and", bson.NewArray(
+			bson.VC.DocumentFromElements(bson.EC.Array("$or", pilots)),
+			bson.VC.DocumentFromElements(bson.EC.Array("$or", glider)),
+			bson.VC.DocumentFromElements(bson.EC.Array("$or", distance)))))
+}
+
+// FireTriggeredWebhook finds a webhook where triggerCount is zero or less, then adds minTriggerValue to triggerCount
+// and sets lastInvoked if it still is. The update is a find-and-modify, so if several servers fire the same
+// webhook at once, only one of them gets it for each time the counter reached zero
+func (db *Database) FireTriggeredWebhook(lastInvoked int64) (*Webhook, error) {
+	for {
+		filter := bson.NewDocument(
+			bson.EC.SubDocumentFromElements("triggerCount",
+				bson.EC.Int64("$lte", 0)))
+
+		candidates := make([]*Webhook, 0)
+		if err := db.find(WEBHOOKS, filter, []findopt.Find{findopt.Limit(1)}, &candidates); err != nil {
+			return nil, err
+		}
+		if len(candidates) < 1 {
+			return nil, ErrNotFound
+		}
+
+		// The counter is incremented instead of reset, so that decrements done in the meantime are not lost
+		candidate := candidates[0]
+		filter.Append(bson.EC.ObjectID("_id", candidate.ID))
+		updateDoc := bson.NewDocument(
+			bson.EC.SubDocumentFromElements("$inc",
+				bson.EC.Int64("triggerCount", triggerStep(candidate))),
+			bson.EC.SubDocumentFromElements("$set",
+				bson.EC.Int64("lastInvoked", lastInvoked)))
+
+		webhook := new(Webhook)
+		err := db.findAndModify(WEBHOOKS, filter, updateDoc, webhook)
+		if err == ErrNotFound {
+			// Another server fired the webhook first
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return webhook, nil
+	}
+}
+
+// UpdateWebhookSecret sets the secret, previousSecret and previousSecretExpires of a webhook
+func (db *Database) UpdateWebhookSecret(webhook *Webhook) error {
+	filter := bson.NewDocument(bson.EC.ObjectID("_id", webhook.ID))
+	updateDoc := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$set",
+			bson.EC.String("secret", webhook.Secret),
+			bson.EC.String("previousSecret", webhook.PreviousSecret),
+			bson.EC.Int64("previousSecretExpires", webhook.PreviousSecretExpires)))
+	uRes, err := db.update(WEBHOOKS, filter, updateDoc)
+	if err != nil {
+		return err
+	}
+	if uRes.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// InsertDelivery stores a new delivery in the deliveries collection
+func (db *Database) InsertDelivery(delivery *Delivery) (string, error) {
+	return db.insertObject(DELIVERIES, delivery)
+}
+
+// GetDeliveries retrieves the deliveries to a webhook, newest first
+func (db *Database) GetDeliveries(webhookID string, limit int64) ([]*Delivery, error) {
+	objectID, err := objectid.FromHex(webhookID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	filter := bson.NewDocument(bson.EC.ObjectID("webhookID", objectID))
+	findopts := []findopt.Find{
+		findopt.Sort(bson.NewDocument(
+			bson.EC.Int64("created", -1),
+			bson.EC.Int64("_id", -1)))}
+	if limit > 0 {
+		findopts = append(findopts, findopt.Limit(limit))
+	}
+
+	deliveries := make([]*Delivery, 0)
+	if err := db.find(DELIVERIES, filter, findopts, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// GetDueDeliveries retrieves the pending deliveries where nextAttempt is not after now, oldest nextAttempt first
+func (db *Database) GetDueDeliveries(now int64, limit int64) ([]*Delivery, error) {
+	filter := bson.NewDocument(
+		bson.EC.String("status", DeliveryPending),
+		bson.EC.SubDocumentFromElements("nextAttempt",
+			bson.EC.Int64("$lte", now)))
+	findopts := []findopt.Find{
+		findopt.Sort(bson.NewDocument(bson.EC.Int64("nextAttempt", 1))),
+		findopt.Limit(limit)}
+
+	deliveries := make([]*Delivery, 0)
+	if err := db.find(DELIVERIES, filter, findopts, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ClaimDelivery sets the nextAttempt of a delivery to leaseUntil with a find-and-modify, if it is still pending and
+// its nextAttempt is not after now, so that only one server gets the delivery
+func (db *Database) ClaimDelivery(delivery *Delivery, now int64, leaseUntil int64) (*Delivery, error) {
+	filter := bson.NewDocument(
+		bson.EC.ObjectID("_id", delivery.ID),
+		bson.EC.String("status", DeliveryPending),
+		bson.EC.SubDocumentFromElements("nextAttempt",
+			bson.EC.Int64("$lte", now)))
+	updateDoc := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$set",
+			bson.EC.Int64("nextAttempt", leaseUntil)))
+
+	claimed := new(Delivery)
+	if err := db.findAndModify(DELIVERIES, filter, updateDoc, claimed); err != nil {
+		return nil, err
+	}
+	claimed.NextAttempt = leaseUntil
+	return claimed, nil
+}
+
+// ReleaseDelivery sets the nextAttempt of a delivery, if it is still pending with the lease in delivery.NextAttempt
+func (db *Database) ReleaseDelivery(delivery *Delivery, nextAttempt int64) error {
+	filter := bson.NewDocument(
+		bson.EC.ObjectID("_id", delivery.ID),
+		bson.EC.String("status", DeliveryPending),
+		bson.EC.Int64("nextAttempt", delivery.NextAttempt))
+	updateDoc := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$set",
+			bson.EC.Int64("nextAttempt", nextAttempt)))
+	_, err := db.update(DELIVERIES, filter, updateDoc)
+	return err
+}
+
+// AddDeliveryAttempt pushes an attempt to the attempts of a delivery, and sets its status and nextAttempt
+func (db *Database) AddDeliveryAttempt(delivery *Delivery, attempt DeliveryAttempt) error {
+	filter := bson.NewDocument(bson.EC.ObjectID("_id", delivery.ID))
+	updateDoc := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$push",
+			bson.EC.SubDocumentFromElements("attempts",
+				bson.EC.Int64("time", attempt.Time),
+				bson.EC.Int32("statusCode", int32(attempt.StatusCode)),
+				bson.EC.String("error", attempt.Error),
+				bson.EC.Int64("duration", attempt.Duration))),
+		bson.EC.SubDocumentFromElements("$set",
+			bson.EC.String("status", delivery.Status),
+			bson.EC.Int64("nextAttempt", delivery.NextAttempt)))
+	uRes, err := db.update(DELIVERIES, filter, updateDoc)
+	if err != nil {
+		return err
+	}
+	if uRes.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
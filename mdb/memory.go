@@ -0,0 +1,357 @@
+package mdb
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryDatabase is a storage backend which keeps all tracks and webhooks in memory, it is used
+// when no mongoDB server is available (for example when running the tests)
+type MemoryDatabase struct {
+	mu         sync.RWMutex
+	tracks     []*Track
+	points     map[string][]Point
+	webhooks   []*Webhook
+	deliveries []*Delivery
+}
+
+// Make sure both storage backends implement the Storage interface
+var (
+	_ Storage = (*Database)(nil)
+	_ Storage = (*MemoryDatabase)(nil)
+)
+
+// NewMemoryDatabase creates a new empty in-memory storage backend
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{
+		tracks:     make([]*Track, 0),
+		points:     make(map[string][]Point),
+		webhooks:   make([]*Webhook, 0),
+		deliveries: make([]*Delivery, 0)}
+}
+
+// InsertTrack stores a copy of the track and its points
+func (mem *MemoryDatabase) InsertTrack(track *Track, points []Point) (string, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	t := *track
+	mem.tracks = append(mem.tracks, &t)
+	mem.points[t.ID.Hex()] = append([]Point(nil), points...)
+	return t.ID.Hex(), nil
+}
+
+// GetTrack retrieves a track by its ID
+func (mem *MemoryDatabase) GetTrack(id string) (*Track, error) {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	for _, track := range mem.tracks {
+		if track.ID.Hex() == id {
+			t := *track
+			return &t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetTrackPoints retrieves the points of a track by the track ID
+func (mem *MemoryDatabase) GetTrackPoints(id string) ([]Point, error) {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	points, ok := mem.points[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]Point(nil), points...), nil
+}
+
+// FindTrackIDs returns the IDs of the tracks matching the filter, in the sort order of the filter
+func (mem *MemoryDatabase) FindTrackIDs(filter *TrackFilter) ([]string, error) {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	tracks := make([]*Track, 0)
+	for _, track := range mem.tracks {
+		if filter.matches(track) && (filter.After == nil || filter.compare(filter.After, track) < 0) {
+			tracks = append(tracks, track)
+		}
+	}
+
+	sort.Slice(tracks, func(i, j int) bool {
+		return filter.compare(tracks[i], tracks[j]) < 0
+	})
+	if filter.Limit > 0 && int64(len(tracks)) > filter.Limit {
+		tracks = tracks[:filter.Limit]
+	}
+
+	ids := make([]string, 0, len(tracks))
+	for _, track := range tracks {
+		ids = append(ids, track.ID.Hex())
+	}
+	return ids, nil
+}
+
+// GetTracksAfter returns the tracks added after the timestamp ts, oldest first
+func (mem *MemoryDatabase) GetTracksAfter(ts int64, limit int64) ([]*Track, error) {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	tracks := make([]*Track, 0)
+	for _, track := range mem.tracks {
+		if track.Ts > ts {
+			t := *track
+			tracks = append(tracks, &t)
+		}
+	}
+
+	sort.SliceStable(tracks, func(i, j int) bool {
+		return tracks[i].Ts < tracks[j].Ts
+	})
+	if limit > 0 && int64(len(tracks)) > limit {
+		tracks = tracks[:limit]
+	}
+	return tracks, nil
+}
+
+// LatestTimestamp finds the timestamp of the latest added track
+func (mem *MemoryDatabase) LatestTimestamp() (int64, error) {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	if len(mem.tracks) < 1 {
+		return -1, ErrNotFound
+	}
+
+	latest := mem.tracks[0].Ts
+	for _, track := range mem.tracks {
+		if track.Ts > latest {
+			latest = track.Ts
+		}
+	}
+	return latest, nil
+}
+
+// CountTracks returns the total amount of stored tracks
+func (mem *MemoryDatabase) CountTracks() (int64, error) {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	return int64(len(mem.tracks)), nil
+}
+
+// DeleteAllTracks removes all stored tracks and their points
+func (mem *MemoryDatabase) DeleteAllTracks() (int64, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	cnt := int64(len(mem.tracks))
+	mem.tracks = make([]*Track, 0)
+	mem.points = make(map[string][]Point)
+	return cnt, nil
+}
+
+// InsertWebhook stores a copy of the webhook
+func (mem *MemoryDatabase) InsertWebhook(webhook *Webhook) (string, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	w := *webhook
+	mem.webhooks = append(mem.webhooks, &w)
+	return w.ID.Hex(), nil
+}
+
+// GetWebhook retrieves a webhook by its ID
+func (mem *MemoryDatabase) GetWebhook(id string) (*Webhook, error) {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	for _, webhook := range mem.webhooks {
+		if webhook.ID.Hex() == id {
+			w := *webhook
+			return &w, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// DeleteWebhook removes a webhook by its ID
+func (mem *MemoryDatabase) DeleteWebhook(id string) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for i, webhook := range mem.webhooks {
+		if webhook.ID.Hex() == id {
+			mem.webhooks = append(mem.webhooks[:i], mem.webhooks[i+1:]...)
+
+			deliveries := make([]*Delivery, 0, len(mem.deliveries))
+			for _, delivery := range mem.deliveries {
+				if delivery.WebhookID != webhook.ID {
+					deliveries = append(deliveries, delivery)
+				}
+			}
+			mem.deliveries = deliveries
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// ClaimPendingTrack clears WebhooksPending of the oldest track where it is set, and returns a copy of the track
+func (mem *MemoryDatabase) ClaimPendingTrack() (*Track, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for _, track := range mem.tracks {
+		if track.WebhooksPending {
+			track.WebhooksPending = false
+			t := *track
+			return &t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// DecrementWebhookTriggers decrements the trigger counter of the webhooks whose filter matches the track by one
+func (mem *MemoryDatabase) DecrementWebhookTriggers(track *Track) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for _, webhook := range mem.webhooks {
+		if webhook.Filter.Matches(track) {
+			webhook.TriggerCount--
+		}
+	}
+	return nil
+}
+
+// FireTriggeredWebhook finds a webhook where the trigger counter is zero or less, adds its MinTriggerValue to the
+// counter and sets LastInvoked, and returns a copy of the webhook as it was before
+func (mem *MemoryDatabase) FireTriggeredWebhook(lastInvoked int64) (*Webhook, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for _, webhook := range mem.webhooks {
+		if webhook.TriggerCount <= 0 {
+			w := *webhook
+			webhook.TriggerCount += triggerStep(webhook)
+			webhook.LastInvoked = lastInvoked
+			return &w, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// UpdateWebhookSecret sets the secret, previous secret and expiry of the previous secret of a webhook
+func (mem *MemoryDatabase) UpdateWebhookSecret(webhook *Webhook) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for _, w := range mem.webhooks {
+		if w.ID == webhook.ID {
+			w.Secret = webhook.Secret
+			w.PreviousSecret = webhook.PreviousSecret
+			w.PreviousSecretExpires = webhook.PreviousSecretExpires
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// InsertDelivery stores a copy of the delivery
+func (mem *MemoryDatabase) InsertDelivery(delivery *Delivery) (string, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	mem.deliveries = append(mem.deliveries, copyDelivery(delivery))
+	return delivery.ID.Hex(), nil
+}
+
+// GetDeliveries returns the deliveries to a webhook, newest first
+func (mem *MemoryDatabase) GetDeliveries(webhookID string, limit int64) ([]*Delivery, error) {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	deliveries := make([]*Delivery, 0)
+	for i := len(mem.deliveries) - 1; i >= 0; i-- {
+		if limit > 0 && int64(len(deliveries)) >= limit {
+			break
+		}
+		if mem.deliveries[i].WebhookID.Hex() == webhookID {
+			deliveries = append(deliveries, copyDelivery(mem.deliveries[i]))
+		}
+	}
+	return deliveries, nil
+}
+
+// GetDueDeliveries returns the pending deliveries where the next attempt is not after now, oldest next attempt first
+func (mem *MemoryDatabase) GetDueDeliveries(now int64, limit int64) ([]*Delivery, error) {
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	deliveries := make([]*Delivery, 0)
+	for _, delivery := range mem.deliveries {
+		if delivery.Status == DeliveryPending && delivery.NextAttempt <= now {
+			deliveries = append(deliveries, copyDelivery(delivery))
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttempt < deliveries[j].NextAttempt
+	})
+	if int64(len(deliveries)) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// ClaimDelivery sets the next attempt of a delivery to leaseUntil, if it is still pending and the next attempt is
+// not after now
+func (mem *MemoryDatabase) ClaimDelivery(delivery *Delivery, now int64, leaseUntil int64) (*Delivery, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for _, d := range mem.deliveries {
+		if d.ID == delivery.ID && d.Status == DeliveryPending && d.NextAttempt <= now {
+			d.NextAttempt = leaseUntil
+			return copyDelivery(d), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ReleaseDelivery sets the next attempt of a delivery, if it is still pending with the lease in delivery.NextAttempt
+func (mem *MemoryDatabase) ReleaseDelivery(delivery *Delivery, nextAttempt int64) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for _, d := range mem.deliveries {
+		if d.ID == delivery.ID && d.Status == DeliveryPending && d.NextAttempt == delivery.NextAttempt {
+			d.NextAttempt = nextAttempt
+		}
+	}
+	return nil
+}
+
+// AddDeliveryAttempt appends an attempt to a delivery, and sets its status and next attempt
+func (mem *MemoryDatabase) AddDeliveryAttempt(delivery *Delivery, attempt DeliveryAttempt) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for _, d := range mem.deliveries {
+		if d.ID == delivery.ID {
+			d.Attempts = append(d.Attempts, attempt)
+			d.Status = delivery.Status
+			d.NextAttempt = delivery.NextAttempt
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// copyDelivery copies a delivery and its attempts, so that the stored delivery is not shared with the caller
+func copyDelivery(delivery *Delivery) *Delivery {
+	d := *delivery
+	d.Attempts = append(make([]DeliveryAttempt, 0, len(delivery.Attempts)), delivery.Attempts...)
+	return &d
+}
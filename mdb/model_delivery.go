@@ -0,0 +1,51 @@
+package mdb
+
+import (
+	"github.com/haakonleg/imt2681-assig2/util"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// The states of a webhook delivery. A delivery is pending until it is delivered, or until it has failed too many
+// times and is moved to the dead-letter state, where it is no longer retried
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// DeliveryAttempt is the result of one attempt at sending a delivery to a webhook
+// StatusCode is the status code of the response (0 if there was no response), Error describes why the attempt
+// failed, and Duration is how long the attempt took in milliseconds
+type DeliveryAttempt struct {
+	Time       int64  `bson:"time" json:"time"`
+	StatusCode int    `bson:"statusCode" json:"status_code,omitempty"`
+	Error      string `bson:"error" json:"error,omitempty"`
+	Duration   int64  `bson:"duration" json:"duration"`
+}
+
+// Delivery is the model of a notification to a webhook in the deliveries collection
+// DeliveryID is sent to the webhook with each attempt, so the receiver can recognize retries of the same delivery
+// Payload is the JSON body that is sent, and NextAttempt is the timestamp when a pending delivery is retried
+type Delivery struct {
+	ID          objectid.ObjectID `bson:"_id" json:"-"`
+	DeliveryID  string            `bson:"deliveryID" json:"id"`
+	WebhookID   objectid.ObjectID `bson:"webhookID" json:"-"`
+	Payload     string            `bson:"payload" json:"-"`
+	Status      string            `bson:"status" json:"status"`
+	Created     int64             `bson:"created" json:"created"`
+	NextAttempt int64             `bson:"nextAttempt" json:"next_attempt,omitempty"`
+	Attempts    []DeliveryAttempt `bson:"attempts" json:"attempts"`
+}
+
+// CreateDelivery creates a pending delivery of the payload to a webhook, which is first attempted at nextAttempt
+func CreateDelivery(webhook *Webhook, deliveryID string, payload []byte, nextAttempt int64) Delivery {
+	return Delivery{
+		ID:          objectid.New(),
+		DeliveryID:  deliveryID,
+		WebhookID:   webhook.ID,
+		Payload:     string(payload),
+		Status:      DeliveryPending,
+		Created:     util.NowMilli(),
+		NextAttempt: nextAttempt,
+		Attempts:    make([]DeliveryAttempt, 0)}
+}
@@ -0,0 +1,96 @@
+package mdb
+
+import (
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/geo"
+	igc "github.com/marni/goigc"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+const (
+	// PointChunkSize is the maximum amount of points stored in each document in the points collection
+	PointChunkSize = 1000
+)
+
+// Point is the model of a single GPS fix in a track
+// Time is the absolute time of the fix, Lat and Lng are in degrees, and the altitudes in metres
+// Valid is false if the flight recorder did not have a 3D GPS fix (fix validity V)
+type Point struct {
+	Time        time.Time `bson:"time" json:"time"`
+	Lat         float64   `bson:"lat" json:"lat"`
+	Lng         float64   `bson:"lng" json:"lng"`
+	PressureAlt int64     `bson:"pressure_alt" json:"pressure_alt"`
+	GNSSAlt     int64     `bson:"gnss_alt" json:"gnss_alt"`
+	Valid       bool      `bson:"valid" json:"valid"`
+}
+
+// LatLng returns the coordinate of the point
+func (p Point) LatLng() geo.LatLng {
+	return geo.LatLng{Lat: p.Lat, Lng: p.Lng}
+}
+
+// LatLngs returns the coordinates of the points
+func LatLngs(points []Point) []geo.LatLng {
+	coords := make([]geo.LatLng, len(points))
+	for i, p := range points {
+		coords[i] = p.LatLng()
+	}
+	return coords
+}
+
+// PointChunk is the model of the documents in the points collection. The points of a track are split
+// into chunks of PointChunkSize points, so that large tracks do not exceed the mongoDB document size limit
+// Seq is the position of the chunk in the track, starting from 0
+type PointChunk struct {
+	ID      objectid.ObjectID `bson:"_id"`
+	TrackID objectid.ObjectID `bson:"track_id"`
+	Seq     int               `bson:"seq"`
+	Points  []Point           `bson:"points"`
+}
+
+// CreatePoints converts the points of a parsed IGC track from goigc into Point objects
+// The IGC B records only contain the time of day, so the date is taken from the header. If the time
+// of a fix is before the previous one, the flight has passed midnight and the date is advanced
+func CreatePoints(track *igc.Track) []Point {
+	date := time.Date(track.Date.Year(), track.Date.Month(), track.Date.Day(), 0, 0, 0, 0, time.UTC)
+
+	points := make([]Point, 0, len(track.Points))
+	var prev time.Time
+	for _, p := range track.Points {
+		h, m, s := p.Time.Clock()
+		t := date.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second)
+		if t.Before(prev) {
+			date = date.AddDate(0, 0, 1)
+			t = t.AddDate(0, 0, 1)
+		}
+		prev = t
+
+		points = append(points, Point{
+			Time:        t,
+			Lat:         p.Lat.Degrees(),
+			Lng:         p.Lng.Degrees(),
+			PressureAlt: p.PressureAltitude,
+			GNSSAlt:     p.GNSSAltitude,
+			Valid:       p.FixValidity == 'A'})
+	}
+	return points
+}
+
+// Splits the points of a track into chunks of PointChunkSize points
+func chunkPoints(trackID objectid.ObjectID, points []Point) []PointChunk {
+	chunks := make([]PointChunk, 0, len(points)/PointChunkSize+1)
+	for seq := 0; seq*PointChunkSize < len(points); seq++ {
+		end := (seq + 1) * PointChunkSize
+		if end > len(points) {
+			end = len(points)
+		}
+
+		chunks = append(chunks, PointChunk{
+			ID:      objectid.New(),
+			TrackID: trackID,
+			Seq:     seq,
+			Points:  points[seq*PointChunkSize : end]})
+	}
+	return chunks
+}
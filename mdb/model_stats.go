@@ -0,0 +1,152 @@
+package mdb
+
+import (
+	"math"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/geo"
+)
+
+const (
+	// The ground speed in km/h above which the glider is considered to be flying
+	takeoffSpeed = 15.0
+	// The time window used to calculate climb and sink rates and ground speed
+	rateWindow = 10 * time.Second
+)
+
+// FlightStats contains statistics about a flight, which are calculated from the points when a track is registered
+// Altitudes are in metres, climb and sink rates in m/s, speeds in km/h and the flight duration in seconds
+type FlightStats struct {
+	TakeoffTime    time.Time `bson:"takeoff_time" json:"takeoff_time"`
+	LandingTime    time.Time `bson:"landing_time" json:"landing_time"`
+	FlightDuration int64     `bson:"flight_duration" json:"flight_duration"`
+	MaxPressureAlt int64     `bson:"max_pressure_alt" json:"max_pressure_alt"`
+	MinPressureAlt int64     `bson:"min_pressure_alt" json:"min_pressure_alt"`
+	MaxGNSSAlt     int64     `bson:"max_gnss_alt" json:"max_gnss_alt"`
+	MinGNSSAlt     int64     `bson:"min_gnss_alt" json:"min_gnss_alt"`
+	MaxClimb       float64   `bson:"max_climb" json:"max_climb"`
+	MaxSink        float64   `bson:"max_sink" json:"max_sink"`
+	MaxSpeed       float64   `bson:"max_speed" json:"max_speed"`
+	AvgSpeed       float64   `bson:"avg_speed" json:"avg_speed"`
+}
+
+// CalFlightStats calculates the flight statistics from the valid points of a track
+// Takeoff and landing are the first and last fix where the ground speed is above takeoffSpeed
+// Climb and sink rates and the maximum ground speed are calculated over windows of rateWindow, to smooth out GPS noise
+func CalFlightStats(points []Point) FlightStats {
+	stats := FlightStats{}
+	points = ValidPoints(points)
+	if len(points) < 1 {
+		return stats
+	}
+
+	// Altitude extremes
+	stats.MaxPressureAlt, stats.MinPressureAlt = points[0].PressureAlt, points[0].PressureAlt
+	stats.MaxGNSSAlt, stats.MinGNSSAlt = points[0].GNSSAlt, points[0].GNSSAlt
+	for _, p := range points {
+		stats.MaxPressureAlt = max64(stats.MaxPressureAlt, p.PressureAlt)
+		stats.MinPressureAlt = min64(stats.MinPressureAlt, p.PressureAlt)
+		stats.MaxGNSSAlt = max64(stats.MaxGNSSAlt, p.GNSSAlt)
+		stats.MinGNSSAlt = min64(stats.MinGNSSAlt, p.GNSSAlt)
+	}
+
+	alt := VarioAltitude(points)
+
+	takeoff, landing := -1, -1
+	j := 0
+	for i := range points {
+		// Find the first point at least rateWindow after point i
+		if j <= i {
+			j = i + 1
+		}
+		for j < len(points) && points[j].Time.Sub(points[i].Time) < rateWindow {
+			j++
+		}
+		if j >= len(points) {
+			break
+		}
+
+		dt := points[j].Time.Sub(points[i].Time).Seconds()
+		rate := float64(alt(points[j])-alt(points[i])) / dt
+		speed := Distance(points[i], points[j]) / dt * 3600
+
+		stats.MaxClimb = math.Max(stats.MaxClimb, rate)
+		stats.MaxSink = math.Min(stats.MaxSink, rate)
+		stats.MaxSpeed = math.Max(stats.MaxSpeed, speed)
+
+		if speed > takeoffSpeed {
+			if takeoff < 0 {
+				takeoff = i
+			}
+			landing = j
+		}
+	}
+
+	// If no flight was detected, use the whole track
+	if takeoff < 0 {
+		takeoff, landing = 0, len(points)-1
+	}
+	stats.TakeoffTime = points[takeoff].Time
+	stats.LandingTime = points[landing].Time
+	stats.FlightDuration = int64(stats.LandingTime.Sub(stats.TakeoffTime).Seconds())
+
+	if stats.FlightDuration > 0 {
+		dist := 0.0
+		for i := takeoff; i < landing; i++ {
+			dist += Distance(points[i], points[i+1])
+		}
+		stats.AvgSpeed = dist / float64(stats.FlightDuration) * 3600
+	}
+
+	stats.MaxClimb = Round2(stats.MaxClimb)
+	stats.MaxSink = Round2(stats.MaxSink)
+	stats.MaxSpeed = Round2(stats.MaxSpeed)
+	stats.AvgSpeed = Round2(stats.AvgSpeed)
+	return stats
+}
+
+// ValidPoints returns the points where the flight recorder had a 3D GPS fix
+func ValidPoints(points []Point) []Point {
+	valid := make([]Point, 0, len(points))
+	for _, p := range points {
+		if p.Valid {
+			valid = append(valid, p)
+		}
+	}
+	return valid
+}
+
+// VarioAltitude returns a function that gives the altitude of a point used for climb and sink rates
+// The pressure altitude is used if the flight recorder logged it, otherwise the GNSS altitude
+func VarioAltitude(points []Point) func(Point) int64 {
+	for _, p := range points {
+		if p.PressureAlt != 0 {
+			return func(p Point) int64 { return p.PressureAlt }
+		}
+	}
+	return func(p Point) int64 { return p.GNSSAlt }
+}
+
+// Distance returns the distance between two points on the earth sphere, in km
+func Distance(a, b Point) float64 {
+	return geo.Haversine(a.LatLng(), b.LatLng()) / 1000
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Round2 rounds a float to two decimals, it is used for the numbers in the API responses
+func Round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
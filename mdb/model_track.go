@@ -1,34 +1,49 @@
 package mdb
 
 import (
+	"strconv"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/geo"
 	"github.com/haakonleg/imt2681-assig2/util"
 	igc "github.com/marni/goigc"
 	"github.com/mongodb/mongo-go-driver/bson/objectid"
 )
 
 // Track is the model of IGC tracks stored in database
+// TrackLength is in metres, calculated with the distance model in DistanceModel. WebhooksPending is set until the
+// webhook dispatcher has counted the track toward the triggers of the webhooks, see ClaimPendingTrack
 type Track struct {
-	ID          objectid.ObjectID `bson:"_id" json:"-"`
-	Ts          int64             `bson:"ts" json:"-"`
-	HDate       string            `bson:"H_date" json:"H_Date"`
-	Pilot       string            `bson:"pilot" json:"pilot"`
-	Glider      string            `bson:"glider" json:"glider"`
-	GliderID    string            `bson:"glider_id" json:"glider_id"`
-	TrackLength string            `bson:"track_length" json:"track_length"`
-	TrackSrcURL string            `bson:"track_src_url" json:"track_src_url"`
+	ID            objectid.ObjectID `bson:"_id" json:"-"`
+	Ts            int64             `bson:"ts" json:"-"`
+	HDate         string            `bson:"H_date" json:"H_Date"`
+	Pilot         string            `bson:"pilot" json:"pilot"`
+	Glider        string            `bson:"glider" json:"glider"`
+	GliderID      string            `bson:"glider_id" json:"glider_id"`
+	TrackLength   float64           `bson:"track_length" json:"track_length"`
+	DistanceModel geo.Model         `bson:"distance_model" json:"distance_model"`
+	TrackSrcURL   string            `bson:"track_src_url" json:"track_src_url"`
+	FlightStats   `bson:",inline"`
+
+	WebhooksPending bool `bson:"webhooksPending,omitempty" json:"-"`
 }
 
-// Creates a new track object out of a parsed IGC track from goigc
-func CreateTrack(igc *igc.Track, url string) Track {
+// Creates a new track object out of a parsed IGC track from goigc, and its points (from CreatePoints)
+// The track length is calculated in metres over all the fixes with the specified distance model
+func CreateTrack(igc *igc.Track, points []Point, url string, model geo.Model) Track {
 	return Track{
-		ID:          objectid.New(),
-		Ts:          util.NowMilli(),
-		HDate:       igc.Date.String(),
-		Pilot:       igc.Pilot,
-		Glider:      igc.GliderType,
-		GliderID:    igc.GliderID,
-		TrackLength: util.CalTrackLen(igc.Points),
-		TrackSrcURL: url}
+		ID:            objectid.New(),
+		Ts:            util.NowMilli(),
+		HDate:         igc.Date.String(),
+		Pilot:         igc.Pilot,
+		Glider:        igc.GliderType,
+		GliderID:      igc.GliderID,
+		TrackLength:   Round2(geo.TrackLength(LatLngs(points), model)),
+		DistanceModel: model,
+		TrackSrcURL:   url,
+		FlightStats:   CalFlightStats(points),
+
+		WebhooksPending: true}
 }
 
 func (t *Track) Field(field string) string {
@@ -40,11 +55,35 @@ func (t *Track) Field(field string) string {
 	case "glider_id":
 		return t.GliderID
 	case "track_length":
-		return t.TrackLength
+		return strconv.FormatFloat(t.TrackLength, 'f', 2, 64)
+	case "distance_model":
+		return string(t.DistanceModel)
 	case "H_date":
 		return t.HDate
 	case "track_src_url":
 		return t.TrackSrcURL
+	case "takeoff_time":
+		return t.TakeoffTime.Format(time.RFC3339)
+	case "landing_time":
+		return t.LandingTime.Format(time.RFC3339)
+	case "flight_duration":
+		return strconv.FormatInt(t.FlightDuration, 10)
+	case "max_pressure_alt":
+		return strconv.FormatInt(t.MaxPressureAlt, 10)
+	case "min_pressure_alt":
+		return strconv.FormatInt(t.MinPressureAlt, 10)
+	case "max_gnss_alt":
+		return strconv.FormatInt(t.MaxGNSSAlt, 10)
+	case "min_gnss_alt":
+		return strconv.FormatInt(t.MinGNSSAlt, 10)
+	case "max_climb":
+		return strconv.FormatFloat(t.MaxClimb, 'f', 2, 64)
+	case "max_sink":
+		return strconv.FormatFloat(t.MaxSink, 'f', 2, 64)
+	case "max_speed":
+		return strconv.FormatFloat(t.MaxSpeed, 'f', 2, 64)
+	case "avg_speed":
+		return strconv.FormatFloat(t.AvgSpeed, 'f', 2, 64)
 	default:
 		return ""
 	}
@@ -1,6 +1,8 @@
 package mdb
 
 import (
+	"strings"
+
 	"github.com/haakonleg/imt2681-assig2/util"
 	"github.com/mongodb/mongo-go-driver/bson/objectid"
 )
@@ -9,17 +11,68 @@ import (
 // MinTriggerValue is the limit to how many new tracks are created before the webhook is notified
 // TriggerCount is decremented by one each time a new track is created, to know when to notify (when it is 0)
 // LastInvoked is a timestamp of when the webhook was last invoked
+// Secret is the key deliveries to the webhook are signed with. After the secret is rotated, deliveries are
+// also signed with PreviousSecret until the timestamp PreviousSecretExpires
+// Filter selects the tracks that count toward the trigger and are sent to the webhook
 type Webhook struct {
-	ID              objectid.ObjectID `bson:"_id" json:"-"`
-	WebhookURL      string            `bson:"webhookURL" json:"webhookURL"`
-	MinTriggerValue int64             `bson:"minTriggerValue" json:"minTriggerValue"`
-	TriggerCount    int64             `bson:"triggerCount" json:"-"`
-	LastInvoked     int64             `bson:"lastInvoked" "json:"-"`
+	ID                    objectid.ObjectID `bson:"_id" json:"-"`
+	WebhookURL            string            `bson:"webhookURL" json:"webhookURL"`
+	MinTriggerValue       int64             `bson:"minTriggerValue" json:"minTriggerValue"`
+	TriggerCount          int64             `bson:"triggerCount" json:"-"`
+	LastInvoked           int64             `bson:"lastInvoked" json:"-"`
+	Secret                string            `bson:"secret" json:"-"`
+	PreviousSecret        string            `bson:"previousSecret" json:"-"`
+	PreviousSecretExpires int64             `bson:"previousSecretExpires" json:"-"`
+	Filter                WebhookFilter     `bson:"filter" json:"filter"`
+}
+
+// WebhookFilter contains the criteria a track must match to notify a webhook, empty fields are not filtered on
+// The track must be flown by one of Pilots, with the glider type Glider, and be at least MinDistance metres long
+// Pilots and Glider are matched case-insensitively, and are stored in lower case
+type WebhookFilter struct {
+	Pilots      []string `bson:"pilots" json:"pilots,omitempty"`
+	Glider      string   `bson:"glider" json:"glider,omitempty"`
+	MinDistance float64  `bson:"minDistance" json:"min_distance,omitempty"`
+}
+
+// Normalize returns the filter with the pilots and glider in lower case and without surrounding whitespace
+func (f WebhookFilter) Normalize() WebhookFilter {
+	pilots := make([]string, 0, len(f.Pilots))
+	for _, pilot := range f.Pilots {
+		if pilot = normalizeName(pilot); pilot != "" {
+			pilots = append(pilots, pilot)
+		}
+	}
+	if len(pilots) == 0 {
+		pilots = nil
+	}
+	return WebhookFilter{Pilots: pilots, Glider: normalizeName(f.Glider), MinDistance: f.MinDistance}
+}
+
+// Matches returns true if the track matches the filter
+func (f *WebhookFilter) Matches(track *Track) bool {
+	if len(f.Pilots) > 0 {
+		found := false
+		for _, pilot := range f.Pilots {
+			found = found || pilot == normalizeName(track.Pilot)
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Glider != "" && f.Glider != normalizeName(track.Glider) {
+		return false
+	}
+	return track.TrackLength >= f.MinDistance
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
 }
 
-func CreateWebhook(webhookUrl string, minTriggerValue int64) Webhook {
-	// If minTriggerValue was not specified, set to 1
-	if minTriggerValue == 0 {
+func CreateWebhook(webhookUrl string, minTriggerValue int64, secret string) Webhook {
+	// If minTriggerValue was not specified (or is not positive), set to 1
+	if minTriggerValue < 1 {
 		minTriggerValue = 1
 	}
 
@@ -29,5 +82,14 @@ func CreateWebhook(webhookUrl string, minTriggerValue int64) Webhook {
 		WebhookURL:      webhookUrl,
 		MinTriggerValue: minTriggerValue,
 		TriggerCount:    minTriggerValue,
-		LastInvoked:     util.NowMilli()}
+		LastInvoked:     util.NowMilli(),
+		Secret:          secret}
+}
+
+// triggerStep returns how much the trigger counter of the webhook is incremented by when it is invoked
+func triggerStep(webhook *Webhook) int64 {
+	if webhook.MinTriggerValue < 1 {
+		return 1
+	}
+	return webhook.MinTriggerValue
 }
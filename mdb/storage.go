@@ -0,0 +1,63 @@
+package mdb
+
+import "errors"
+
+// ErrNotFound is returned by the storage backends when the requested object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Storage is the interface for the storage backends used by the API. The handlers only talk to
+// this interface, so the API can run on top of mongoDB (Database) or in memory (MemoryDatabase)
+type Storage interface {
+	// InsertTrack stores a new track and its points, and returns its ID
+	InsertTrack(track *Track, points []Point) (string, error)
+	// GetTrack retrieves a track by its ID (hex encoded ObjectID)
+	GetTrack(id string) (*Track, error)
+	// GetTrackPoints retrieves the points of a track by the track ID, in chronological order
+	GetTrackPoints(id string) ([]Point, error)
+	// FindTrackIDs returns the IDs of the tracks matching the filter, in the sort order of the filter
+	FindTrackIDs(filter *TrackFilter) ([]string, error)
+	// GetTracksAfter returns tracks with a timestamp higher than ts sorted by timestamp,
+	// oldest first. The amount of tracks is limited to limit, if it is over 0
+	GetTracksAfter(ts int64, limit int64) ([]*Track, error)
+	// LatestTimestamp returns the timestamp of the latest added track
+	LatestTimestamp() (int64, error)
+	// CountTracks returns the total amount of stored tracks
+	CountTracks() (int64, error)
+	// DeleteAllTracks removes all stored tracks and their points, and returns how many were deleted
+	DeleteAllTracks() (int64, error)
+
+	// InsertWebhook stores a new webhook and returns its ID
+	InsertWebhook(webhook *Webhook) (string, error)
+	// GetWebhook retrieves a webhook by its ID (hex encoded ObjectID)
+	GetWebhook(id string) (*Webhook, error)
+	// DeleteWebhook removes a webhook and its deliveries by its ID (hex encoded ObjectID)
+	DeleteWebhook(id string) error
+	// ClaimPendingTrack finds a track with WebhooksPending set and atomically clears it, so that each track is
+	// counted toward the webhook triggers by one server only. Returns ErrNotFound if there are no pending tracks
+	ClaimPendingTrack() (*Track, error)
+	// DecrementWebhookTriggers decrements the trigger counter of the webhooks whose filter matches the track by one
+	DecrementWebhookTriggers(track *Track) error
+	// FireTriggeredWebhook finds a webhook where the trigger counter has reached zero, and atomically adds its
+	// MinTriggerValue to the counter and sets LastInvoked. The webhook is returned as it was before the update, or
+	// ErrNotFound if no counter has reached zero. Each time a counter reaches zero it is only returned once
+	FireTriggeredWebhook(lastInvoked int64) (*Webhook, error)
+	// UpdateWebhookSecret stores the secret, previous secret and expiry of the previous secret of a webhook
+	UpdateWebhookSecret(webhook *Webhook) error
+
+	// InsertDelivery stores a new webhook delivery and returns its ID
+	InsertDelivery(delivery *Delivery) (string, error)
+	// GetDeliveries returns the deliveries to a webhook, newest first. The amount is limited to limit, if it is over 0
+	GetDeliveries(webhookID string, limit int64) ([]*Delivery, error)
+	// GetDueDeliveries returns the pending deliveries that are due for an attempt at the timestamp now, the ones
+	// that have been due the longest first. The amount is limited to limit
+	GetDueDeliveries(now int64, limit int64) ([]*Delivery, error)
+	// ClaimDelivery atomically sets the next attempt of a delivery to leaseUntil if it is still pending and due at
+	// the timestamp now, so that other servers do not claim it until the lease is over. Returns the delivery as it
+	// is stored, or ErrNotFound if it has been claimed or attempted since
+	ClaimDelivery(delivery *Delivery, now int64, leaseUntil int64) (*Delivery, error)
+	// ReleaseDelivery gives up the lease on a claimed delivery that was not attempted, by setting its next attempt
+	// to nextAttempt. It does nothing if the delivery has been attempted or claimed again since
+	ReleaseDelivery(delivery *Delivery, nextAttempt int64) error
+	// AddDeliveryAttempt records an attempt at a delivery, and stores the status and next attempt of the delivery
+	AddDeliveryAttempt(delivery *Delivery, attempt DeliveryAttempt) error
+}
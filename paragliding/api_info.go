@@ -28,7 +28,7 @@ func (aih *ApiInfoHandler) getAPIInfo(req *router.Request) {
 		Info:    "Service for Paragliding tracks.",
 		Version: "v1"}
 
-	req.SendJSON(&response, http.StatusOK)
+	req.Respond(&response, http.StatusOK, router.JSON, router.MSGPACK)
 }
 
 // uptime returns the app uptime in ISO 8601 duration format
@@ -1,26 +1,47 @@
 package paragliding
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/haakonleg/imt2681-assig2/admin"
 	"github.com/haakonleg/imt2681-assig2/mdb"
 	"github.com/haakonleg/imt2681-assig2/router"
+	"github.com/haakonleg/imt2681-assig2/scoring"
 	"github.com/haakonleg/imt2681-assig2/ticker"
 	"github.com/haakonleg/imt2681-assig2/track"
 	"github.com/haakonleg/imt2681-assig2/webhook"
 )
 
+const (
+	// compressMinSize is the size in bytes that responses must have to be compressed
+	compressMinSize = 1024
+	// shutdownTimeout is how long the server waits for requests and webhook deliveries to finish when it is stopped
+	shutdownTimeout = 30 * time.Second
+)
+
 // App must be instantiated with the url to the mongodb database, database name and the port for the API to listen on
+// If Storage is set, it is used as the storage backend instead of connecting to mongoDB
+// If ScoringRules is set, it replaces the default multipliers used when scoring flights
+// If CORSOrigins is set, browsers on these origins are allowed to use the API ("*" allows all origins)
+// If WebhookDelivery is set, it replaces the default policy for retrying failed webhook deliveries
 type App struct {
-	MongoURL    string
-	DBName      string
-	ListenPort  string
-	TickerLimit int64
+	MongoURL        string
+	DBName          string
+	ListenPort      string
+	TickerLimit     int64
+	Storage         mdb.Storage
+	ScoringRules    *scoring.Rules
+	CORSOrigins     []string
+	WebhookDelivery *webhook.DeliveryPolicy
 
-	db             *mdb.Database
+	db             mdb.Storage
 	infoHandler    *ApiInfoHandler
 	trackHandler   *track.TrackHandler
 	tickerHandler  *ticker.TickerHandler
@@ -29,65 +50,114 @@ type App struct {
 }
 
 func (app *App) configureRoutes(r *router.Router) {
+	r.Constraint("trackfield", track.ValidateTrackField)
+
 	// Redirect to /paragliding/api
 	r.Handle("GET", "/paragliding", func(req *router.Request) {
 		req.Redirect("/paragliding/api")
 	})
 
+	// Public API routes
+	api := r.Group("/paragliding/api")
+	api.Handle("GET", "", app.infoHandler.getAPIInfo).Name("api")
+
 	// Track routes
-	r.Handle("GET", "/paragliding/api", app.infoHandler.getAPIInfo)
-	r.Handle("POST", "/paragliding/api/track", app.trackHandler.PostTrack)
-	r.Handle("GET", "/paragliding/api/track", app.trackHandler.GetAllTracks)
-	r.Handle("GET", "/paragliding/api/track/{id}", app.trackHandler.GetTrack)
-	r.Handle("GET", "/paragliding/api/track/{id}/{field}", app.trackHandler.GetTrackField)
+	api.Handle("POST", "/track", app.trackHandler.PostTrack, router.Decompress)
+	api.Handle("GET", "/track", app.trackHandler.GetAllTracks).Name("tracks")
+	api.Handle("GET", "/track/{id:objectid}", app.trackHandler.GetTrack).Name("track")
+	api.Handle("GET", "/track/{id:objectid}/points", app.trackHandler.GetTrackPoints).Name("track_points")
+	api.Handle("GET", "/track/{id:objectid}/export", app.trackHandler.GetTrackExport).Name("track_export")
+	api.Handle("GET", "/track/{id:objectid}/analysis", app.trackHandler.GetTrackAnalysis).Name("track_analysis")
+	api.Handle("GET", "/track/{id:objectid}/score", app.trackHandler.GetTrackScore).Name("track_score")
+	api.Handle("GET", "/track/{id:objectid}/{field:trackfield}", app.trackHandler.GetTrackField).Name("track_field")
 
 	// Ticker routes
-	r.Handle("GET", "/paragliding/api/ticker/latest", app.tickerHandler.GetLatestTimestamp)
-	r.Handle("GET", "/paragliding/api/ticker", app.tickerHandler.GetTicker)
-	r.Handle("GET", "/paragliding/api/ticker/{timestamp}", app.tickerHandler.GetTicker)
+	api.Handle("GET", "/ticker/latest", app.tickerHandler.GetLatestTimestamp).Name("ticker_latest")
+	api.Handle("GET", "/ticker", app.tickerHandler.GetTicker).Name("ticker")
+	api.Handle("GET", "/ticker/{timestamp:int}", app.tickerHandler.GetTicker).Name("ticker_after")
 
 	// Webhook routes
-	r.Handle("POST", "/paragliding/api/webhook/new_track", app.webhookHandler.PostWebhook)
-	r.Handle("GET", "/paragliding/api/webhook/new_track/{id}", app.webhookHandler.GetWebhook)
-	r.Handle("DELETE", "/paragliding/api/webhook/new_track/{id}", app.webhookHandler.DeleteWebhook)
+	api.Handle("POST", "/webhook/new_track", app.webhookHandler.PostWebhook)
+	api.Handle("GET", "/webhook/new_track/{id:objectid}", app.webhookHandler.GetWebhook).Name("webhook")
+	api.Handle("DELETE", "/webhook/new_track/{id:objectid}", app.webhookHandler.DeleteWebhook)
+	api.Handle("POST", "/webhook/new_track/{id:objectid}/secret", app.webhookHandler.RotateWebhookSecret).Name("webhook_secret")
+	api.Handle("GET", "/webhook/new_track/{id:objectid}/deliveries", app.webhookHandler.GetDeliveries).Name("webhook_deliveries")
 
 	// Admin routes
-	r.Handle("GET", "/admin/api/tracks_count", app.adminHandler.GetTrackCount)
-	r.Handle("DELETE", "/admin/api/tracks", app.adminHandler.DeleteAllTracks)
-}
-
-func (app *App) configureValidators(r *router.Router) {
-	r.Validate("id", track.ValidateTrackID)
-	r.Validate("field", track.ValidateTrackField)
-	r.Validate("timestamp", ticker.ValidateTimestamp)
+	admin := r.Group("/admin/api")
+	admin.Handle("GET", "/tracks_count", app.adminHandler.GetTrackCount)
+	admin.Handle("DELETE", "/tracks", app.adminHandler.DeleteAllTracks)
 }
 
 // StartServer starts listening and serving the API server
 func (app *App) StartServer() {
-	// Try connect to mongoDB
-	app.db = &mdb.Database{MongoURL: app.MongoURL, DBName: app.DBName}
-	app.db.CreateConnection()
-	fmt.Println("Connected to mongoDB")
+	if app.Storage != nil {
+		app.db = app.Storage
+	} else {
+		// Try connect to mongoDB
+		db := &mdb.Database{MongoURL: app.MongoURL, DBName: app.DBName}
+		db.CreateConnection()
+		app.db = db
+		fmt.Println("Connected to mongoDB")
+	}
 
 	// Create handlers
 	app.infoHandler = NewInfoHandler()
 	app.trackHandler = track.NewTrackHandler(app.db)
+	if app.ScoringRules != nil {
+		app.trackHandler.SetScoringRules(*app.ScoringRules)
+	}
 	app.tickerHandler = ticker.NewTickerHandler(app.TickerLimit, app.db)
 	app.webhookHandler = webhook.NewWebhookHandler(app.db)
+	if app.WebhookDelivery != nil {
+		app.webhookHandler.SetDeliveryPolicy(*app.WebhookDelivery)
+	}
+	app.webhookHandler.Start()
 	app.adminHandler = admin.NewAdminHandler(app.db)
 
-	// Registers a callback so that when a new track is registered, the webhook handler will check
+	// Send an event to the webhook dispatcher when a new track is registered, so that it will check
 	// if any webhooks should be triggered
-	app.trackHandler.SetTrackRegisterCallback(app.webhookHandler.CheckInvokeWebhooks)
+	app.trackHandler.SetTrackEvents(app.webhookHandler.Events())
 
 	// Instantiate router, and configure the handlers and paths
 	r := router.NewRouter()
+	r.Use(router.Logger, router.Recover)
+	if len(app.CORSOrigins) > 0 {
+		r.EnableCORS(router.CORSOptions{
+			AllowedOrigins: app.CORSOrigins,
+			AllowedHeaders: []string{"Content-Type"},
+			MaxAge:         600})
+	}
+	r.EnableCompression(router.CompressionOptions{MinSize: compressMinSize})
 	app.configureRoutes(r)
-	app.configureValidators(r)
 
-	// Start listen
+	// Start listen, and shut down gracefully on SIGINT or SIGTERM
+	server := &http.Server{Addr: ":" + app.ListenPort, Handler: r}
+	stopped := make(chan struct{})
+	go app.shutdownOnSignal(server, stopped)
+
 	fmt.Printf("Server listening on port %s\n", app.ListenPort)
-	if err := http.ListenAndServe(":"+app.ListenPort, r); err != nil {
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal(err.Error())
 	}
+	<-stopped
+}
+
+// shutdownOnSignal waits for SIGINT or SIGTERM, then stops accepting requests and waits for the requests and
+// webhook deliveries in progress to finish, for at most shutdownTimeout. stopped is closed when it is done
+func (app *App) shutdownOnSignal(server *http.Server, stopped chan struct{}) {
+	defer close(stopped)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	fmt.Println("Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+	}
+	if err := app.webhookHandler.Shutdown(ctx); err != nil {
+		fmt.Println("Webhook deliveries were not finished:", err)
+	}
 }
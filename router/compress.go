@@ -0,0 +1,250 @@
+package router
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The problem codes of request bodies in a content coding that is not supported, or that can not be decoded
+const (
+	CodeUnsupportedEncoding = "unsupported_encoding"
+	CodeInvalidEncoding     = "invalid_encoding"
+)
+
+// CompressionOptions configures response compression for a router
+// Responses are compressed if they are at least MinSize bytes and have a compressible content type (text, JSON,
+// XML and MessagePack). Level is the compression level of the compress/gzip and compress/zlib packages (0 means the
+// default level)
+type CompressionOptions struct {
+	MinSize int
+	Level   int
+}
+
+// encoder is a content coding that responses can be compressed with
+type encoder struct {
+	name      string
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error)
+}
+
+// The supported content codings, in order of preference. The deflate coding is the zlib format (RFC 1950), not raw
+// deflate. Brotli is not supported, since there is no encoder for it in the standard library
+var encoders = []encoder{
+	{"gzip", func(w io.Writer, level int) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, level) }},
+	{"deflate", func(w io.Writer, level int) (io.WriteCloser, error) { return zlib.NewWriterLevel(w, level) }}}
+
+// EnableCompression enables compression of responses, with a content coding negotiated with the Accept-Encoding
+// header of the request
+func (ro *Router) EnableCompression(options CompressionOptions) {
+	if options.Level == 0 {
+		options.Level = gzip.DefaultCompression
+	}
+	ro.compression = &options
+}
+
+// negotiateEncoding chooses the supported content coding with the highest quality in the Accept-Encoding header,
+// ties are won by the preferred coding. Returns nil if none of them are acceptable
+func negotiateEncoding(acceptEncoding string) *encoder {
+	var best *encoder
+	bestQ := 0.0
+	for i := range encoders {
+		q, specific := 0.0, false
+		for _, part := range strings.Split(acceptEncoding, ",") {
+			params := strings.Split(part, ";")
+			coding := strings.ToLower(strings.TrimSpace(params[0]))
+			if coding != encoders[i].name && (coding != "*" || specific) {
+				continue
+			}
+
+			q = 1
+			for _, param := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+					if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+						q = v
+					}
+				}
+			}
+			specific = coding != "*"
+		}
+		if q > bestQ {
+			best, bestQ = &encoders[i], q
+		}
+	}
+	return best
+}
+
+// compressible returns true if responses of the content type are worth compressing
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/msgpack", "application/x-msgpack":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// compressWriter is a http.ResponseWriter that compresses the body of the response. The body is buffered until
+// it reaches the minimum size, so that small responses can be sent uncompressed. Close must be called at the end
+// of the request
+type compressWriter struct {
+	http.ResponseWriter
+	options *CompressionOptions
+	encoder *encoder
+	head    bool
+
+	status  int
+	buf     []byte
+	started bool
+	writer  io.WriteCloser
+}
+
+func newCompressWriter(w http.ResponseWriter, r *http.Request, options *CompressionOptions) *compressWriter {
+	return &compressWriter{
+		ResponseWriter: w,
+		options:        options,
+		encoder:        negotiateEncoding(r.Header.Get("Accept-Encoding")),
+		head:           r.Method == "HEAD"}
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = statusCode
+
+	// Responses without a body are sent as they are
+	if cw.head || statusCode < http.StatusOK || statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.started {
+		if cw.writer != nil {
+			return cw.writer.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.options.MinSize {
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start writes the headers and the buffered body, compressed if the response is large enough and compressible
+func (cw *compressWriter) start(large bool) error {
+	cw.started = true
+	header := cw.Header()
+
+	if compressible(header.Get("Content-Type")) && header.Get("Content-Encoding") == "" {
+		AddVary(header, "Accept-Encoding")
+		if large && cw.encoder != nil {
+			w, err := cw.encoder.newWriter(cw.ResponseWriter, cw.options.Level)
+			if err != nil {
+				return err
+			}
+			cw.writer = w
+			header.Set("Content-Encoding", cw.encoder.name)
+			header.Del("Content-Length")
+
+			// A strong entity tag identifies the exact bytes of the response, so it must change with the encoding
+			if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+				header.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+cw.encoder.name+`"`)
+			}
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if cw.writer != nil {
+		_, err := cw.writer.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// Close sends the rest of the response
+func (cw *compressWriter) Close() error {
+	if cw.status == 0 {
+		return nil
+	}
+	if !cw.started {
+		if err := cw.start(false); err != nil {
+			return err
+		}
+	}
+	if cw.writer != nil {
+		return cw.writer.Close()
+	}
+	return nil
+}
+
+// Decompress is middleware that decodes request bodies sent with a Content-Encoding of gzip or deflate (zlib), so
+// that the handler can read them as if they were not encoded. Requests in other content codings are rejected with 415
+func Decompress(next HandlerFunc) HandlerFunc {
+	return func(req *Request) {
+		encoding := strings.ToLower(strings.TrimSpace(req.R.Header.Get("Content-Encoding")))
+		switch encoding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			gr, err := gzip.NewReader(req.R.Body)
+			if err != nil {
+				req.SendError(&Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidEncoding, Message: "The request body is not valid gzip"})
+				return
+			}
+			req.R.Body = readCloser{gr, req.R.Body}
+		case "deflate":
+			zr, err := zlib.NewReader(req.R.Body)
+			if err != nil {
+				req.SendError(&Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidEncoding, Message: "The request body is not valid zlib"})
+				return
+			}
+			req.R.Body = readCloser{zr, req.R.Body}
+		default:
+			req.W.Header().Set("Accept-Encoding", "gzip, deflate")
+			req.SendError(&Error{StatusCode: http.StatusUnsupportedMediaType, Code: CodeUnsupportedEncoding,
+				Message: "Unsupported content encoding " + encoding})
+			return
+		}
+
+		if encoding != "" && encoding != "identity" {
+			req.R.Header.Del("Content-Encoding")
+			req.R.Header.Del("Content-Length")
+			req.R.ContentLength = -1
+		}
+		next(req)
+	}
+}
+
+// readCloser reads from a decoder of a request body, and closes the body when it is closed
+type readCloser struct {
+	io.Reader
+	body io.Closer
+}
+
+func (rc readCloser) Close() error {
+	return rc.body.Close()
+}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NotModified sets the ETag and Last-Modified headers of the response, and checks the conditional headers of the
+// request (If-None-Match, or If-Modified-Since if it is not set). If the client already has the current version of
+// the resource, a 304 response is sent and true is returned, and the handler should not send a response
+// etag must be a quoted strong entity tag, and lastModified can be the zero time if it is not known
+func (req *Request) NotModified(etag string, lastModified time.Time) bool {
+	header := req.W.Header()
+	header.Set("ETag", etag)
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if req.R.Method != "GET" && req.R.Method != "HEAD" {
+		return false
+	}
+
+	notModified := false
+	if inm := req.R.Header.Get("If-None-Match"); inm != "" {
+		notModified = etagMatches(inm, etag)
+	} else if ims := req.R.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil {
+			notModified = !lastModified.Truncate(time.Second).After(t)
+		}
+	}
+
+	if notModified {
+		req.W.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
+
+// etagMatches returns true if the If-None-Match header matches the entity tag, using the weak comparison
+// Tags of compressed responses match the tag of the uncompressed response, see compressWriter
+func etagMatches(ifNoneMatch string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		for _, enc := range encoders {
+			if suffix := "-" + enc.name + `"`; strings.HasSuffix(tag, suffix) {
+				tag = strings.TrimSuffix(tag, suffix) + `"`
+			}
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// builtinConstraints are the constraints that can be used for variables in all routers
+// objectid is a hex encoded mongoDB ObjectID (24 characters), int is a base 10 integer that is decoded to int64
+var builtinConstraints = map[string]ValidatorFunc{
+	"objectid": validateObjectID,
+	"int":      validateInt}
+
+// parseParam parses a variable segment without the curly brackets, such as "id", "id:objectid", "field:enum(a,b)"
+// or "path...". catchAll is true if the variable matches the rest of the path
+func parseParam(segment string, constraints map[string]ValidatorFunc) (par param, catchAll bool, err error) {
+	if strings.HasSuffix(segment, "...") {
+		catchAll = true
+		segment = strings.TrimSuffix(segment, "...")
+	}
+
+	name, spec := segment, ""
+	if i := strings.Index(segment, ":"); i >= 0 {
+		name, spec = segment[:i], segment[i+1:]
+	}
+	if name == "" {
+		return par, false, errors.New("variable without name")
+	}
+	par.name = name
+	par.catchAll = catchAll
+
+	switch {
+	case spec == "":
+	case catchAll:
+		return par, false, errors.New("catch-all " + name + " can not have a constraint")
+	case strings.HasPrefix(spec, "enum(") && strings.HasSuffix(spec, ")"):
+		par.constraint = enumConstraint(strings.Split(spec[len("enum("):len(spec)-1], ","))
+	default:
+		constraint, ok := constraints[spec]
+		if !ok {
+			return par, false, errors.New("unknown constraint " + spec)
+		}
+		par.constraint = constraint
+	}
+	return par, catchAll, nil
+}
+
+// enumConstraint returns a constraint that is met if the variable is one of the values
+func enumConstraint(values []string) ValidatorFunc {
+	return func(variable string) (bool, interface{}) {
+		for _, value := range values {
+			if variable == value {
+				return true, variable
+			}
+		}
+		return false, nil
+	}
+}
+
+func validateObjectID(variable string) (bool, interface{}) {
+	if len(variable) != 24 {
+		return false, nil
+	}
+	for _, ch := range variable {
+		if !strings.ContainsRune("0123456789abcdef", ch) {
+			return false, nil
+		}
+	}
+	return true, variable
+}
+
+func validateInt(variable string) (bool, interface{}) {
+	i, err := strconv.ParseInt(variable, 10, 64)
+	if err != nil {
+		return false, nil
+	}
+	return true, i
+}
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSOptions configures cross-origin resource sharing for a router
+// AllowedOrigins are the origins that may use the API, "*" allows all origins. AllowedHeaders are the request
+// headers that may be used in addition to the CORS-safelisted headers, and MaxAge is how many seconds a browser
+// may cache the result of a preflight request (0 means that it is not sent)
+type CORSOptions struct {
+	AllowedOrigins []string
+	AllowedHeaders []string
+	MaxAge         int
+}
+
+// EnableCORS enables CORS headers on responses to allowed origins, and answering of CORS preflight requests
+func (ro *Router) EnableCORS(options CORSOptions) {
+	ro.cors = &options
+}
+
+// allowedOrigin returns the value of the Access-Control-Allow-Origin header for the origin of a request,
+// or an empty string if the origin is not allowed
+func (co *CORSOptions) allowedOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range co.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// setHeaders sets the CORS headers on the response if the request is from an allowed origin
+func (co *CORSOptions) setHeaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+	if origin := co.allowedOrigin(r.Header.Get("Origin")); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+// preflight sets the headers answering a CORS preflight request, if the origin and requested method are allowed
+func (co *CORSOptions) preflight(w http.ResponseWriter, r *http.Request, allowed []string) {
+	method := r.Header.Get("Access-Control-Request-Method")
+	if co.allowedOrigin(r.Header.Get("Origin")) == "" || method == "" {
+		return
+	}
+
+	for _, m := range allowed {
+		if m == method {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+			if len(co.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(co.AllowedHeaders, ", "))
+			}
+			if co.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(co.MaxAge))
+			}
+			return
+		}
+	}
+}
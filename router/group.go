@@ -0,0 +1,64 @@
+package router
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// Group is a sub-router where all routes share a path prefix and middleware, so that parts of an API can be
+// mounted, secured and versioned independently. The middleware of a group runs after the middleware registered
+// with Router.Use and the middleware of its parent groups, and before the middleware of the route
+type Group struct {
+	router     *Router
+	parent     *Group
+	prefix     string
+	middleware []Middleware
+}
+
+// Group creates a group of routes with the path prefix and middleware
+func (ro *Router) Group(prefix string, middleware ...Middleware) *Group {
+	g := &Group{
+		router:     ro,
+		prefix:     cleanPrefix(prefix),
+		middleware: middleware}
+	ro.groups = append(ro.groups, g)
+	return g
+}
+
+// Group creates a group inside the group, its prefix is appended to the prefix of the group
+func (g *Group) Group(prefix string, middleware ...Middleware) *Group {
+	sub := g.router.Group(g.prefix+cleanPrefix(prefix), middleware...)
+	sub.parent = g
+	return sub
+}
+
+// Use registers middleware that is used for all routes in the group, including the ones already registered
+func (g *Group) Use(middleware ...Middleware) {
+	g.middleware = append(g.middleware, middleware...)
+}
+
+// Handle registers a handler function for the specified HTTP method and URL pattern, relative to the prefix
+// of the group. See Router.Handle
+func (g *Group) Handle(method string, pattern string, handler HandlerFunc, middleware ...Middleware) *Route {
+	return g.router.handle(g, method, g.prefix+pattern, handler, middleware)
+}
+
+// cleanPrefix makes sure a prefix starts with a slash and does not end with one, the root prefix is empty
+func cleanPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// middlewareNames returns the function names of middleware, used when printing the router
+func middlewareNames(middleware []Middleware) string {
+	names := make([]string, 0, len(middleware))
+	for _, mw := range middleware {
+		name := runtime.FuncForPC(reflect.ValueOf(mw).Pointer()).Name()
+		names = append(names, name[strings.LastIndex(name, "/")+1:])
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,81 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	// MaxJSONSize is the maximum size in bytes of JSON request bodies
+	MaxJSONSize = 1 << 20
+)
+
+// JSONErrorKind is the reason a JSON request body could not be decoded
+type JSONErrorKind int
+
+const (
+	// JSONEmpty means that the request body was empty
+	JSONEmpty JSONErrorKind = iota
+	// JSONTooLarge means that the request body was larger than MaxJSONSize
+	JSONTooLarge
+	// JSONSyntax means that the request body was not valid JSON
+	JSONSyntax
+	// JSONType means that a value in the request body had the wrong type for the field
+	JSONType
+	// JSONUnknownField means that the request body contained a field that is not in the struct
+	JSONUnknownField
+)
+
+// JSONError is returned by ParseJSONRequest when the request body could not be decoded
+// Field is the JSON field that caused the error, for JSONType and JSONUnknownField errors
+type JSONError struct {
+	Kind  JSONErrorKind
+	Field string
+	Err   error
+}
+
+func (e *JSONError) Error() string {
+	return e.Err.Error()
+}
+
+// RouterError converts the error to an Error that can be sent as response to the request
+func (e *JSONError) RouterError() *Error {
+	switch e.Kind {
+	case JSONEmpty:
+		return &Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidJSON, Message: "Missing JSON body"}
+	case JSONTooLarge:
+		return &Error{StatusCode: http.StatusRequestEntityTooLarge, Code: CodeBodyTooLarge, Message: fmt.Sprintf("The JSON body is larger than %d bytes", MaxJSONSize)}
+	case JSONType:
+		return &Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidFieldType, Message: "Invalid type for JSON field " + e.Field,
+			Params: []ParamError{{Name: e.Field, Reason: "has the wrong type"}}}
+	case JSONUnknownField:
+		return &Error{StatusCode: http.StatusBadRequest, Code: CodeUnknownField, Message: "Unknown JSON field " + e.Field,
+			Params: []ParamError{{Name: e.Field, Reason: "is not a known field"}}}
+	}
+	return &Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidJSON, Message: "Invalid JSON"}
+}
+
+// newJSONError finds the kind of an error from decoding a JSON request body
+func newJSONError(err error) *JSONError {
+	var typeErr *json.UnmarshalTypeError
+	var sizeErr *http.MaxBytesError
+
+	switch {
+	case err == io.EOF:
+		return &JSONError{Kind: JSONEmpty, Err: err}
+	case errors.As(err, &sizeErr):
+		return &JSONError{Kind: JSONTooLarge, Err: err}
+	case errors.As(err, &typeErr):
+		return &JSONError{Kind: JSONType, Field: typeErr.Field, Err: err}
+	}
+
+	// The json package does not have a type for unknown field errors, the message is: json: unknown field "name"
+	var field string
+	if n, _ := fmt.Sscanf(err.Error(), "json: unknown field %q", &field); n == 1 {
+		return &JSONError{Kind: JSONUnknownField, Field: field, Err: err}
+	}
+	return &JSONError{Kind: JSONSyntax, Err: err}
+}
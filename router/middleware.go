@@ -0,0 +1,56 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware is the function template for middleware. It takes the next handler in the chain and returns
+// a handler that wraps it, which can do work before and after calling next, or not call it at all
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Chain wraps a handler in middleware, the first middleware is the outermost
+func Chain(handler HandlerFunc, middleware ...Middleware) HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Recover is middleware that recovers from panics in the handlers, and sends a 500 status code instead of
+// closing the connection
+func Recover(next HandlerFunc) HandlerFunc {
+	return func(req *Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				fmt.Printf("panic: %v\n%s", err, debug.Stack())
+				req.SendError(&Error{StatusCode: http.StatusInternalServerError, Code: CodeInternalError, Message: "Internal server error"})
+			}
+		}()
+		next(req)
+	}
+}
+
+// Logger is middleware that prints the ID, method, path, status code and duration of each request
+func Logger(next HandlerFunc) HandlerFunc {
+	return func(req *Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: req.W, status: http.StatusOK}
+		req.W = sw
+		next(req)
+		fmt.Printf("[%s] %s %s %d %s\n", req.ID, req.R.Method, req.R.URL.Path, sw.status, time.Since(start))
+	}
+}
+
+// statusWriter is a http.ResponseWriter that remembers the status code of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(statusCode int) {
+	sw.status = statusCode
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
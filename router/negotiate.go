@@ -0,0 +1,153 @@
+package router
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CodeNotAcceptable is the error code used when none of the offered response types are acceptable to the client
+const CodeNotAcceptable = "not_acceptable"
+
+// The media types of each response type, the first is the content type used in responses
+var mediaTypes = map[ResponseType][]string{
+	JSON:    {"application/json"},
+	TEXT:    {"text/plain"},
+	CSV:     {"text/csv"},
+	MSGPACK: {"application/msgpack", "application/x-msgpack"}}
+
+// ContentType returns the content type of responses of the response type
+func (rt ResponseType) ContentType() string {
+	return mediaTypes[rt][0]
+}
+
+// CSVMarshaler is implemented by values that can be sent as CSV. The first record is the header
+type CSVMarshaler interface {
+	MarshalCSV() [][]string
+}
+
+// Respond sends value in the representation that the client prefers in its Accept header, of the offered response
+// types. If there is no Accept header, the first offered type is used. If none of them are acceptable, a 406 error
+// is sent. Values sent as CSV must implement CSVMarshaler, and values sent as TEXT are formatted with fmt.Sprint
+func (req *Request) Respond(value interface{}, statusCode int, offers ...ResponseType) error {
+	AddVary(req.W.Header(), "Accept")
+	responseType, ok := req.Negotiate(offers...)
+	if !ok {
+		types := make([]string, 0, len(offers))
+		for _, offer := range offers {
+			types = append(types, offer.ContentType())
+		}
+		return req.SendError(&Error{StatusCode: http.StatusNotAcceptable, Code: CodeNotAcceptable,
+			Message: "This resource is available as " + strings.Join(types, ", ")})
+	}
+
+	switch responseType {
+	case TEXT:
+		return req.SendText(fmt.Sprint(value), statusCode)
+	case CSV:
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		w.WriteAll(value.(CSVMarshaler).MarshalCSV())
+		return req.SendData([]byte(b.String()), CSV.ContentType(), statusCode)
+	case MSGPACK:
+		data, err := marshalMsgpack(value)
+		if err != nil {
+			req.logError(err)
+			return req.SendError(&Error{StatusCode: http.StatusInternalServerError, Code: CodeInternalError, Message: "Internal server error"})
+		}
+		return req.SendData(data, MSGPACK.ContentType(), statusCode)
+	}
+	return req.SendJSON(value, statusCode)
+}
+
+// Negotiate returns the offered response type that Respond sends for the request, or false if none of them are
+// acceptable to the client
+func (req *Request) Negotiate(offers ...ResponseType) (ResponseType, bool) {
+	return negotiate(req.R.Header.Get("Accept"), offers)
+}
+
+// negotiate chooses the offered response type with the highest quality in the Accept header. The quality of a
+// type is taken from the most specific media range that matches it, and ties are won by the first offer
+func negotiate(accept string, offers []ResponseType) (ResponseType, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+	ranges := parseAccept(accept)
+
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		q := -1.0
+		specificity := -1
+		for _, mediaType := range mediaTypes[offer] {
+			for _, r := range ranges {
+				if s := r.matches(mediaType); s > specificity {
+					specificity, q = s, r.q
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// mediaRange is a media range in an Accept header, such as text/*;q=0.5
+type mediaRange struct {
+	mainType, subType string
+	q                 float64
+}
+
+// matches returns how specific the media range is if it matches the media type (2 for an exact match, 1 for
+// type/* and 0 for */*), or -1 if it does not match
+func (r mediaRange) matches(mediaType string) int {
+	parts := strings.SplitN(mediaType, "/", 2)
+	switch {
+	case r.mainType == "*" && r.subType == "*":
+		return 0
+	case r.mainType == parts[0] && r.subType == "*":
+		return 1
+	case r.mainType == parts[0] && r.subType == parts[1]:
+		return 2
+	}
+	return -1
+}
+
+// parseAccept parses the media ranges in an Accept header
+func parseAccept(accept string) []mediaRange {
+	ranges := make([]mediaRange, 0)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		slash := strings.Index(mediaType, "/")
+		if slash < 0 {
+			continue
+		}
+
+		r := mediaRange{mainType: mediaType[:slash], subType: mediaType[slash+1:], q: 1}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// AddVary adds a request header to the Vary header of a response, unless it is already listed
+func AddVary(header http.Header, name string) {
+	for _, value := range header["Vary"] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
@@ -0,0 +1,81 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// The error codes used by the router, and by handlers for common errors. The codes are stable, so that clients
+// can rely on them instead of the detail message
+const (
+	CodeNotFound          = "not_found"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeInvalidParameters = "invalid_parameters"
+	CodeInvalidJSON       = "invalid_json"
+	CodeUnknownField      = "unknown_field"
+	CodeInvalidFieldType  = "invalid_field_type"
+	CodeBodyTooLarge      = "body_too_large"
+	CodeInvalidID         = "invalid_id"
+	CodeDatabaseError     = "database_error"
+	CodeInternalError     = "internal_error"
+)
+
+// ProblemContentType is the content type of error responses (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// RequestIDHeader is the header containing the ID of a request, it is used by clients to correlate requests
+// with error responses and logs
+const RequestIDHeader = "X-Request-ID"
+
+// Error is an error sent in response to a request, as an RFC 7807 problem details object
+// Code is a stable machine readable code for the kind of error, and Message is the human readable detail.
+// Type and Title are set from the status code and Instance to the ID of the request when the error is sent
+// Params lists the invalid parameters or fields if there are any
+type Error struct {
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	StatusCode int          `json:"status"`
+	Code       string       `json:"code"`
+	Message    string       `json:"detail"`
+	Instance   string       `json:"instance,omitempty"`
+	Params     []ParamError `json:"invalid_params,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// SendError sends an error as a problem details object in response to the request in case an error occured
+func (req *Request) SendError(err *Error) error {
+	// Copy the error, so that errors shared between requests are not modified
+	problem := *err
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.StatusCode)
+	}
+	if problem.Code == "" {
+		problem.Code = CodeInternalError
+	}
+	problem.Instance = req.ID
+
+	res, mErr := json.Marshal(&problem)
+	if mErr != nil {
+		req.logError(mErr)
+		return mErr
+	}
+	return req.SendData(res, ProblemContentType, problem.StatusCode)
+}
+
+// requestID returns the ID of a request from the X-Request-ID header, or a new random ID if it is not set
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" && len(id) <= 128 {
+		return id
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,131 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParamError describes a parameter or field of a request that is invalid, and the reason
+type ParamError struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// InvalidParams creates a 400 error listing the invalid parameters
+func InvalidParams(params ...ParamError) *Error {
+	return &Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidParameters, Message: "Invalid query parameters", Params: params}
+}
+
+// BindQuery decodes the query parameters of the request into the fields of a struct, dst is a pointer to the struct
+// The name of the parameter is set with the "query" tag, fields without it are ignored. Supported field types are
+// string, int, int64, float64, bool and time.Time. The rules for a parameter are set with these tags:
+//
+//	default: the value used if the parameter is not set
+//	min, max: the lowest and highest allowed value of numbers
+//	enum: a comma separated list of the allowed values
+//	layout: the time layout used to parse time.Time fields (RFC 3339 if it is not set)
+//
+// If any parameters are invalid, a 400 error listing each of them is returned
+func (req *Request) BindQuery(dst interface{}) *Error {
+	query := req.R.URL.Query()
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+
+	params := make([]ParamError, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("query")
+		if name == "" {
+			continue
+		}
+
+		value := query.Get(name)
+		if value == "" {
+			value = field.Tag.Get("default")
+		}
+		if value == "" {
+			continue
+		}
+
+		if msg := bindValue(v.Field(i), field.Tag, value); msg != "" {
+			params = append(params, ParamError{Name: name, Reason: msg})
+		}
+	}
+
+	if len(params) > 0 {
+		return InvalidParams(params...)
+	}
+	return nil
+}
+
+// bindValue decodes a parameter value into a struct field and checks the rules in the struct tags
+// Returns a message describing the problem if the value is invalid, or an empty string
+func bindValue(field reflect.Value, tag reflect.StructTag, value string) string {
+	if enum := tag.Get("enum"); enum != "" {
+		allowed := strings.Split(enum, ",")
+		found := false
+		for _, a := range allowed {
+			found = found || a == value
+		}
+		if !found {
+			return "must be one of " + strings.Join(allowed, ", ")
+		}
+	}
+
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case int, int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "must be an integer"
+		}
+		if msg := checkRange(float64(i), tag); msg != "" {
+			return msg
+		}
+		field.SetInt(i)
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "must be a number"
+		}
+		if msg := checkRange(f, tag); msg != "" {
+			return msg
+		}
+		field.SetFloat(f)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "must be true or false"
+		}
+		field.SetBool(b)
+	case time.Time:
+		layout := tag.Get("layout")
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		ts, err := time.Parse(layout, value)
+		if err != nil {
+			return "must be a time in the format " + layout
+		}
+		field.Set(reflect.ValueOf(ts))
+	default:
+		panic(fmt.Sprintf("BindQuery: unsupported field type %s", field.Type()))
+	}
+	return ""
+}
+
+// checkRange checks that a number is within the min and max tags
+func checkRange(f float64, tag reflect.StructTag) string {
+	if min, err := strconv.ParseFloat(tag.Get("min"), 64); err == nil && f < min {
+		return "must be at least " + tag.Get("min")
+	}
+	if max, err := strconv.ParseFloat(tag.Get("max"), 64); err == nil && f > max {
+		return "must be at most " + tag.Get("max")
+	}
+	return ""
+}
@@ -2,85 +2,105 @@ package router
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"net/http"
 )
 
+// ResponseType is a representation that responses can be sent in, see Request.Respond
 type ResponseType int
 
 const (
 	JSON ResponseType = iota
 	TEXT
+	CSV
+	MSGPACK
 )
 
-type Error struct {
-	StatusCode int    `json:"-"`
-	Message    string `json:"error"`
-}
-
 // Request contains the context of the HTTP request, it also has some helper methods
+// Router is the router that routed the request, and ID is the ID of the request (see RequestIDHeader)
 type Request struct {
-	W    http.ResponseWriter
-	R    *http.Request
-	Vars map[string]interface{}
+	W      http.ResponseWriter
+	R      *http.Request
+	Vars   map[string]interface{}
+	Router *Router
+	ID     string
 }
 
 // SetResponseType sets the response type of the HTTP response
 func (req *Request) SetResponseType(responseType ResponseType) {
-	switch responseType {
-	case JSON:
-		req.W.Header().Set("Content-Type", "application/json")
-	case TEXT:
-		req.W.Header().Set("Content-Type", "text/plain")
-	}
+	req.W.Header().Set("Content-Type", responseType.ContentType())
 }
 
 // SendJSON sends a json response, parameter jsonStruct is a struct
-// that contains the json fields
-func (req *Request) SendJSON(jsonStruct interface{}, statusCode int) {
-	req.SetResponseType(JSON)
+// that contains the json fields. If jsonStruct can not be encoded, a 500 error is sent instead
+// The returned error is the encoding or write error, if there was one
+func (req *Request) SendJSON(jsonStruct interface{}, statusCode int) error {
 	res, err := json.Marshal(jsonStruct)
 	if err != nil {
-		log.Fatal(err)
+		req.logError(err)
+		if sErr := req.SendError(&Error{StatusCode: http.StatusInternalServerError, Code: CodeInternalError, Message: "Internal server error"}); sErr != nil {
+			return sErr
+		}
+		return err
 	}
-	req.W.WriteHeader(statusCode)
-	req.W.Write(res)
+
+	req.SetResponseType(JSON)
+	return req.write(res, statusCode)
 }
 
 // SendText sends a plain text message as response to the request
-func (req *Request) SendText(text string, statusCode int) {
+func (req *Request) SendText(text string, statusCode int) error {
 	req.SetResponseType(TEXT)
+	return req.write([]byte(text), statusCode)
+}
+
+// SendData sends a response with the specified content type
+func (req *Request) SendData(data []byte, contentType string, statusCode int) error {
+	req.W.Header().Set("Content-Type", contentType)
+	return req.write(data, statusCode)
+}
+
+// write writes the status code and body of the response, and logs write errors (e.g. if the client disconnected)
+func (req *Request) write(body []byte, statusCode int) error {
 	req.W.WriteHeader(statusCode)
-	fmt.Fprint(req.W, text)
+	if _, err := req.W.Write(body); err != nil {
+		req.logError(err)
+		return err
+	}
+	return nil
+}
+
+// logError prints an error that happened while handling the request, with the method and path of the request
+func (req *Request) logError(err error) {
+	fmt.Printf("[%s] %s %s: %v\n", req.ID, req.R.Method, req.R.URL.Path, err)
 }
 
 // ParseJSONRequest parses the JSON contents of a POST request, takes
 // a struct as parameter with JSON fields and writes the contents to it
+// The body must be a single JSON value of at most MaxJSONSize bytes, without fields that are not in the struct
+// If the body can not be decoded, a *JSONError is returned
 func (req *Request) ParseJSONRequest(jsonStruct interface{}) error {
-	body, err := ioutil.ReadAll(req.R.Body)
-	defer req.R.Body.Close()
+	body := http.MaxBytesReader(req.W, req.R.Body, MaxJSONSize)
+	defer body.Close()
 
-	if err != nil {
-		fmt.Println(err)
-		return err
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(jsonStruct); err != nil {
+		return newJSONError(err)
 	}
 
-	err = json.Unmarshal(body, jsonStruct)
-	if err != nil {
-		fmt.Println(err)
-		return err
+	// There must not be anything after the JSON value
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected data after JSON value")
+		}
+		return newJSONError(err)
 	}
-
 	return nil
 }
 
-// SendError sends a JSON error message in response to the request in case an error occured
-func (req *Request) SendError(err *Error) {
-	req.SendJSON(err, err.StatusCode)
-}
-
 // Redirect redirects the request to another URL
 func (req *Request) Redirect(path string) {
 	http.Redirect(req.W, req.R, path, http.StatusPermanentRedirect)
@@ -1,7 +1,13 @@
 /*
 	Package router implements a simple router that can route requests based on the URL paths/subpaths and
-	HTTP verbs. Dynamic "variables" are supported, by enclosing a path name in curly brackets. Validator
-	functions for these can also be registered, where if false is returned, a 404 status code will be sent.
+	HTTP verbs. Dynamic "variables" are supported, by enclosing a path name in curly brackets. A variable can
+	have a constraint after a colon, such as {id:objectid}, {ts:int} or {field:enum(pilot,glider)}, and if
+	the constraint is not met a 404 status code will be sent. A last segment like {path...} matches the rest
+	of the path. The names and constraints of variables belong to the route they are registered with.
+	Middleware can be registered for all requests with Router.Use, or for a single route when it is registered.
+	If a path matches but there is no handler for the HTTP method, a 405 status code is sent with the Allow header.
+	HEAD requests are served by the GET handlers, and OPTIONS requests (including CORS preflight) are answered
+	automatically.
 */
 
 package router
@@ -9,6 +15,7 @@ package router
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 )
 
@@ -20,155 +27,394 @@ type HandlerFunc func(*Request)
 // and a generic interface{} where variables can be decoded to other types
 type ValidatorFunc func(string) (bool, interface{})
 
-// The routes are stored in a trie structure
+// The keys of the children of a routeNode that are variable segments and catch-all segments
+const (
+	varKey      = "{var}"
+	catchAllKey = "{...}"
+)
+
+// The routes are stored in a trie structure. All variable segments at the same position share one child, the
+// names and constraints of the variables are stored with each route
 type routeNode struct {
 	children map[string]*routeNode
-	varNames map[string]string
-	handlers map[string]HandlerFunc
+	routes   map[string]*Route
+}
+
+// Route is a handler registered for a HTTP method and URL pattern
+// The middleware of the route runs after the middleware of its group, if it was registered in a group
+type Route struct {
+	router     *Router
+	group      *Group
+	name       string
+	pattern    string
+	handler    HandlerFunc
+	middleware []Middleware
+	params     []param
+}
+
+// param is a variable segment in the URL pattern of a route
+type param struct {
+	name       string
+	constraint ValidatorFunc
+	catchAll   bool
 }
 
-// Print prints the routeNode tree
+func newRouteNode() *routeNode {
+	return &routeNode{
+		children: make(map[string]*routeNode, 0),
+		routes:   make(map[string]*Route, 0)}
+}
+
+// Print prints the routeNode tree, with the routes registered on each node
 func (rt *routeNode) Print(depth int) {
-	tree := rt.children
-	for k, v := range tree {
-		fmt.Printf("Depth %d %s \n", depth, k)
-		fmt.Printf("Vars: %v, Handlers: %v\n", v.varNames, v.handlers)
-		if v.children != nil {
-			v.Print(depth + 1)
+	keys := make([]string, 0, len(rt.children))
+	for k := range rt.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	indent := strings.Repeat("  ", depth)
+	for _, k := range keys {
+		v := rt.children[k]
+		fmt.Printf("%s/%s\n", indent, k)
+		for _, method := range v.methods() {
+			r := v.routes[method]
+			fmt.Printf("%s  %s %s", indent, method, r.pattern)
+			if r.name != "" {
+				fmt.Printf(" name=%s", r.name)
+			}
+			if r.group != nil {
+				fmt.Printf(" group=%s", r.group.prefix)
+			}
+			if len(r.middleware) > 0 {
+				fmt.Printf(" middleware=%s", middlewareNames(r.middleware))
+			}
+			fmt.Println()
 		}
+		v.Print(depth + 1)
 	}
 }
 
-func (rt *routeNode) addRoute(method string, path string, handler HandlerFunc) {
-	subpaths := strings.Split(path, "/")
+// addRoute adds a route to the tree. Constraints in the pattern are looked up in constraints
+// An error is returned if the pattern is invalid, or if a route is already registered for the method and pattern
+func (rt *routeNode) addRoute(method string, pattern string, handler HandlerFunc, constraints map[string]ValidatorFunc) (*Route, error) {
+	subpaths := splitPath(pattern)
 
-	varName := ""
+	r := &Route{pattern: pattern, handler: handler}
 	currNode := rt
-	for _, p := range subpaths {
-		if len(p) == 0 {
-			continue
-		}
+	for i, p := range subpaths {
+		key := p
 
-		// This route contains a variable
+		// This segment is a variable
 		if p[0] == '{' && p[len(p)-1] == '}' {
-			varName = p[1 : len(p)-1]
-			p = "{var}"
+			par, catchAll, err := parseParam(p[1:len(p)-1], constraints)
+			if err != nil {
+				return nil, fmt.Errorf("invalid route %s: %s", pattern, err)
+			}
+			if catchAll && i != len(subpaths)-1 {
+				return nil, fmt.Errorf("invalid route %s: catch-all %s must be the last segment", pattern, p)
+			}
+			for _, other := range r.params {
+				if other.name == par.name {
+					return nil, fmt.Errorf("invalid route %s: variable %s is used twice", pattern, par.name)
+				}
+			}
+
+			r.params = append(r.params, par)
+			key = varKey
+			if catchAll {
+				key = catchAllKey
+			}
 		}
 
-		node, ok := currNode.children[p]
+		node, ok := currNode.children[key]
 		if !ok {
-			node = &routeNode{
-				children: make(map[string]*routeNode, 0),
-				varNames: make(map[string]string, 0),
-				handlers: make(map[string]HandlerFunc, 0)}
-			currNode.children[p] = node
+			node = newRouteNode()
+			currNode.children[key] = node
 		}
 		currNode = node
 	}
 
-	if varName != "" {
-		currNode.varNames[method] = varName
+	if existing, ok := currNode.routes[method]; ok {
+		return nil, fmt.Errorf("route %s %s conflicts with %s %s", method, pattern, method, existing.pattern)
+	}
+	currNode.routes[method] = r
+	return r, nil
+}
+
+// methods returns the HTTP methods that have a route registered on the node, sorted
+func (rt *routeNode) methods() []string {
+	methods := make([]string, 0, len(rt.routes))
+	for method := range rt.routes {
+		methods = append(methods, method)
 	}
-	currNode.handlers[method] = handler
+	sort.Strings(methods)
+	return methods
 }
 
-// Walks the route tree and finds a routeNode corresponding to the specified method and URL path
-// on the way it also captures all variables into a slice
-func (rt *routeNode) resolveRoute(method string, path string) (*routeNode, []string) {
-	subpaths := strings.Split(path, "/")
+// allowedMethods returns the HTTP methods that can be used for the path with the variable values, sorted
+// Only the methods of routes whose constraints accept the values are allowed, if there are none nil is returned
+// HEAD is allowed if there is a GET handler, and OPTIONS is always allowed
+func (rt *routeNode) allowedMethods(values []string) []string {
+	methods := make([]string, 0, len(rt.routes)+2)
+	get := false
+	for _, method := range rt.methods() {
+		if _, ok := rt.routes[method].bind(values); ok {
+			methods = append(methods, method)
+			get = get || method == "GET"
+		}
+	}
+	if len(methods) == 0 {
+		return nil
+	}
 
-	vars := make([]string, 0)
-	currNode := rt
-	for _, p := range subpaths {
-		if len(p) == 0 {
+	if _, ok := rt.routes["HEAD"]; get && !ok {
+		methods = append(methods, "HEAD")
+	}
+	if _, ok := rt.routes["OPTIONS"]; !ok {
+		methods = append(methods, "OPTIONS")
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Walks the route tree and finds the routeNode with routes corresponding to the path segments, on the way it
+// also captures the values of the variable segments. Constant segments are preferred over variables, and
+// variables over catch-all segments. If a branch does not lead to a node with routes, the next one is tried
+func (rt *routeNode) resolveRoute(subpaths []string, values []string) (*routeNode, []string) {
+	if len(subpaths) == 0 {
+		if len(rt.routes) == 0 {
+			return nil, nil
+		}
+		return rt, values
+	}
+
+	// First try to match it against a constant route
+	if tree, ok := rt.children[subpaths[0]]; ok {
+		if node, vals := tree.resolveRoute(subpaths[1:], values); node != nil {
+			return node, vals
+		}
+	}
+
+	// Try to find a variable route
+	if tree, ok := rt.children[varKey]; ok {
+		if node, vals := tree.resolveRoute(subpaths[1:], append(values, subpaths[0])); node != nil {
+			return node, vals
+		}
+	}
+
+	// The catch-all gets the rest of the path
+	if tree, ok := rt.children[catchAllKey]; ok && len(tree.routes) > 0 {
+		return tree, append(values, strings.Join(subpaths, "/"))
+	}
+	return nil, nil
+}
+
+// bind checks the values of the variable segments against the constraints of the route, and returns the variables
+// for the Request object. False is returned if a constraint is not met
+func (r *Route) bind(values []string) (map[string]interface{}, bool) {
+	vars := make(map[string]interface{}, len(r.params))
+	for i, par := range r.params {
+		if par.constraint == nil {
+			vars[par.name] = values[i]
 			continue
 		}
 
-		// First try to match it against a constant route
-		tree, ok := currNode.children[p]
+		ok, variable := par.constraint(values[i])
 		if !ok {
-			// Try to find a variable route
-			tree, ok = currNode.children["{var}"]
-			if ok {
-				vars = append(vars, tree.varNames[method])
-				vars = append(vars, p)
-			} else {
-				return nil, nil
-			}
+			return nil, false
 		}
-		currNode = tree
+		vars[par.name] = variable
 	}
+	return vars, true
+}
 
-	return currNode, vars
+// splitPath splits a URL path into its segments, ignoring empty segments
+func splitPath(path string) []string {
+	subpaths := make([]string, 0)
+	for _, p := range strings.Split(path, "/") {
+		if len(p) > 0 {
+			subpaths = append(subpaths, p)
+		}
+	}
+	return subpaths
 }
 
 // Router is the context for a router object
 type Router struct {
-	routes     routeNode
-	validators map[string]ValidatorFunc
+	routes      *routeNode
+	groups      []*Group
+	named       map[string]*Route
+	constraints map[string]ValidatorFunc
+	middleware  []Middleware
+	cors        *CORSOptions
+	compression *CompressionOptions
 }
 
 // NewRouter creates a new Router object
 func NewRouter() *Router {
-	return &Router{
-		routes: routeNode{
-			children: make(map[string]*routeNode, 0),
-			varNames: make(map[string]string, 0),
-			handlers: make(map[string]HandlerFunc, 0)},
-		validators: make(map[string]ValidatorFunc, 0)}
+	ro := &Router{
+		routes:      newRouteNode(),
+		named:       make(map[string]*Route, 0),
+		constraints: make(map[string]ValidatorFunc, 0)}
+	for name, constraint := range builtinConstraints {
+		ro.constraints[name] = constraint
+	}
+	return ro
 }
 
 func (ro *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := &Request{W: w, R: r, Router: ro, ID: requestID(r)}
+	w.Header().Set(RequestIDHeader, req.ID)
+
+	// Add the CORS headers to requests from allowed origins
+	if ro.cors != nil {
+		ro.cors.setHeaders(w, r)
+	}
+
+	// Compress the response if the client accepts it
+	if ro.compression != nil {
+		cw := newCompressWriter(w, r, ro.compression)
+		defer func() {
+			if err := cw.Close(); err != nil {
+				req.logError(err)
+			}
+		}()
+		req.W = cw
+	}
+
+	// Wrap the handler in the middleware registered with Use
+	Chain(ro.route(req), ro.middleware...)(req)
+}
+
+// route finds the handler for the request and adds the variables in the path to it
+// If no route is found, a handler that sends 404 is returned
+func (ro *Router) route(req *Request) HandlerFunc {
 	// Find the node for this path
-	route, vars := ro.routes.resolveRoute(r.Method, r.URL.Path)
+	node, values := ro.routes.resolveRoute(splitPath(req.R.URL.Path), nil)
 
 	// No registered route found
-	if route == nil {
-		http.NotFound(w, r)
-		return
-	}
-
-	req := &Request{W: w, R: r}
-
-	// Create a slice of variables to add to the Request object
-	retVars := make(map[string]interface{})
-	for i := 0; i < len(vars); i += 2 {
-		// Check if there is a validator for this variable
-		validator, ok := ro.validators[vars[i]]
-		if ok {
-			// Call the validator, if not succeed, send 404 code
-			ok, variable := validator(vars[i+1])
-			if !ok {
-				http.NotFound(w, r)
-				return
+	if node == nil {
+		return notFound
+	}
+
+	// Check if there is a handler for the HTTP method, HEAD is served with the GET handler without the body
+	r, ok := node.routes[req.R.Method]
+	head := false
+	if !ok && req.R.Method == "HEAD" {
+		r, ok = node.routes["GET"]
+		head = ok
+	}
+
+	if !ok {
+		// If the constraints of none of the routes accept the variables, the path does not exist for any method
+		allowed := node.allowedMethods(values)
+		if allowed == nil {
+			return notFound
+		}
+		if req.R.Method == "OPTIONS" {
+			return func(req *Request) {
+				ro.options(req, allowed)
 			}
-			// Or add it to the slice
-			retVars[vars[i]] = variable
-		} else {
-			// Add unvalidated variable to slice
-			retVars[vars[i]] = vars[i+1]
+		}
+
+		// Handlers are only registered for other methods
+		return func(req *Request) {
+			req.W.Header().Set("Allow", strings.Join(allowed, ", "))
+			req.SendError(&Error{StatusCode: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed,
+				Message: "The method " + req.R.Method + " is not allowed for this path"})
 		}
 	}
-	req.Vars = retVars
 
-	// Check if there is a handler for the HTTP method
-	handler, ok := route.handlers[r.Method]
-	if ok {
-		// Call the handler
-		handler(req)
-		return
+	// Check the variables against the constraints of the route, if not succeed, send 404 code
+	vars, ok := r.bind(values)
+	if !ok {
+		return notFound
 	}
+	req.Vars = vars
 
-	// No handler registered
-	http.NotFound(w, r)
+	handler := r.chain()
+	if head {
+		return func(req *Request) {
+			req.W = headWriter{req.W}
+			handler(req)
+		}
+	}
+	return handler
+}
+
+// chain wraps the handler of the route in the middleware of the route, and the middleware of its groups
+func (r *Route) chain() HandlerFunc {
+	handler := Chain(r.handler, r.middleware...)
+	for g := r.group; g != nil; g = g.parent {
+		handler = Chain(handler, g.middleware...)
+	}
+	return handler
+}
+
+// options answers an OPTIONS request with the allowed methods, and answers CORS preflight requests if CORS is enabled
+func (ro *Router) options(req *Request, allowed []string) {
+	req.W.Header().Set("Allow", strings.Join(allowed, ", "))
+	if ro.cors != nil {
+		ro.cors.preflight(req.W, req.R, allowed)
+	}
+	req.W.WriteHeader(http.StatusNoContent)
+}
+
+func notFound(req *Request) {
+	req.SendError(&Error{StatusCode: http.StatusNotFound, Code: CodeNotFound, Message: "No resource found at " + req.R.URL.Path})
+}
+
+// headWriter is a http.ResponseWriter that discards the body, used to answer HEAD requests with GET handlers
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (hw headWriter) Write(b []byte) (int, error) {
+	return len(b), nil
 }
 
 // Handle registers a handler function for the specified HTTP method and URL pattern
-func (ro *Router) Handle(method string, path string, handler HandlerFunc) {
-	ro.routes.addRoute(method, path, handler)
+// The middleware is only used for this route, and runs after the middleware registered with Use
+// Handle panics if the pattern is invalid or conflicts with a registered route, like http.ServeMux
+// The returned Route can be given a name, to generate URLs to it with URLFor
+func (ro *Router) Handle(method string, pattern string, handler HandlerFunc, middleware ...Middleware) *Route {
+	return ro.handle(nil, method, pattern, handler, middleware)
+}
+
+func (ro *Router) handle(group *Group, method string, pattern string, handler HandlerFunc, middleware []Middleware) *Route {
+	r, err := ro.routes.addRoute(method, pattern, handler, ro.constraints)
+	if err != nil {
+		panic(err)
+	}
+	r.router = ro
+	r.group = group
+	r.middleware = middleware
+	return r
+}
+
+// Use registers middleware that is used for all requests, including requests that do not match a route
+func (ro *Router) Use(middleware ...Middleware) {
+	ro.middleware = append(ro.middleware, middleware...)
+}
+
+// Print prints the middleware, groups and routes of the router
+func (ro *Router) Print() {
+	if len(ro.middleware) > 0 {
+		fmt.Printf("Middleware: %s\n", middlewareNames(ro.middleware))
+	}
+	for _, g := range ro.groups {
+		fmt.Printf("Group %s", g.prefix)
+		if len(g.middleware) > 0 {
+			fmt.Printf(" middleware=%s", middlewareNames(g.middleware))
+		}
+		fmt.Println()
+	}
+	ro.routes.Print(0)
 }
 
-// Validate registers a validation function for the specified dynamic variable
-func (ro *Router) Validate(varName string, validator ValidatorFunc) {
-	ro.validators[varName] = validator
+// Constraint registers a named constraint that can be used for variables in routes registered after it,
+// in addition to the builtin constraints
+func (ro *Router) Constraint(name string, validator ValidatorFunc) {
+	ro.constraints[name] = validator
 }
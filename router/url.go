@@ -0,0 +1,80 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Name gives the route a name, which is used to generate URLs to it with URLFor
+// Name panics if the name is already used by another route
+func (r *Route) Name(name string) *Route {
+	if existing, ok := r.router.named[name]; ok && existing != r {
+		panic(fmt.Sprintf("route name %s is used by both %s and %s", name, existing.pattern, r.pattern))
+	}
+	r.name = name
+	r.router.named[name] = r
+	return r
+}
+
+// URLFor generates the path to the route with the name, where the variables in the pattern are replaced by the
+// values in vars. The values are formatted with fmt.Sprint and escaped, and must meet the constraints of the route
+func (ro *Router) URLFor(name string, vars map[string]interface{}) (string, error) {
+	r, ok := ro.named[name]
+	if !ok {
+		return "", errors.New("no route named " + name)
+	}
+	return r.url(vars)
+}
+
+// URLFor generates the path to a named route in the router that routed the request
+func (req *Request) URLFor(name string, vars map[string]interface{}) (string, error) {
+	return req.Router.URLFor(name, vars)
+}
+
+// url generates the path to the route with the variables replaced by the values in vars
+func (r *Route) url(vars map[string]interface{}) (string, error) {
+	var b strings.Builder
+	n := 0
+	for _, p := range splitPath(r.pattern) {
+		b.WriteString("/")
+		if p[0] != '{' || p[len(p)-1] != '}' {
+			b.WriteString(p)
+			continue
+		}
+
+		par := r.params[n]
+		n++
+		v, ok := vars[par.name]
+		if !ok {
+			return "", fmt.Errorf("missing variable %s for route %s", par.name, r.name)
+		}
+		value := fmt.Sprint(v)
+		if value == "" {
+			return "", fmt.Errorf("empty variable %s for route %s", par.name, r.name)
+		}
+
+		// A catch-all can contain several segments, which are escaped one by one
+		if par.catchAll {
+			segments := strings.Split(value, "/")
+			for i, segment := range segments {
+				segments[i] = url.PathEscape(segment)
+			}
+			b.WriteString(strings.Join(segments, "/"))
+			continue
+		}
+
+		if par.constraint != nil {
+			if ok, _ := par.constraint(value); !ok {
+				return "", fmt.Errorf("variable %s does not meet the constraint of route %s", par.name, r.name)
+			}
+		}
+		b.WriteString(url.PathEscape(value))
+	}
+
+	if b.Len() == 0 {
+		return "/", nil
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,111 @@
+/*
+	Package scoring implements online contest (XContest/OLC style) scoring of flights. A flight is scored
+	as a free distance through up to 3 turnpoints, as a flat triangle and as an FAI triangle, and the
+	distance of each is multiplied by the multiplier for the type to get the points. The best score counts.
+*/
+
+package scoring
+
+import (
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/geo"
+	"github.com/haakonleg/imt2681-assig2/mdb"
+)
+
+// The types of scored flights
+const (
+	FreeDistance = "free_distance"
+	FlatTriangle = "flat_triangle"
+	FAITriangle  = "fai_triangle"
+)
+
+// Rules contains the multipliers (points per km) for each type of flight, and the maximum closing
+// distance of triangles as a share of the perimeter
+type Rules struct {
+	FreeDistance float64 `json:"free_distance"`
+	FlatTriangle float64 `json:"flat_triangle"`
+	FAITriangle  float64 `json:"fai_triangle"`
+	ClosingRatio float64 `json:"closing_ratio"`
+}
+
+// DefaultRules are the XContest multipliers
+var DefaultRules = Rules{
+	FreeDistance: 1.0,
+	FlatTriangle: 1.2,
+	FAITriangle:  1.4,
+	ClosingRatio: 0.2}
+
+// Turnpoint is a point of the flight used in a score
+type Turnpoint struct {
+	Time time.Time `json:"time"`
+	Lat  float64   `json:"lat"`
+	Lng  float64   `json:"lng"`
+}
+
+// Score is the score of a flight as one type of flight
+// Distance is the scored distance in km. For triangles it is the perimeter minus the closing distance,
+// and the turnpoints are the three corners. Points is the distance multiplied by Multiplier
+type Score struct {
+	Type            string      `json:"type"`
+	Distance        float64     `json:"distance"`
+	ClosingDistance float64     `json:"closing_distance,omitempty"`
+	Multiplier      float64     `json:"multiplier"`
+	Points          float64     `json:"points"`
+	Turnpoints      []Turnpoint `json:"turnpoints"`
+}
+
+// Result contains the scores of a flight for each type of flight, and the best of them
+type Result struct {
+	Best   string  `json:"best"`
+	Points float64 `json:"points"`
+	Scores []Score `json:"scores"`
+	Rules  Rules   `json:"rules"`
+}
+
+// ScoreFlight scores a flight using the valid points of the track
+func ScoreFlight(points []mdb.Point, rules Rules) *Result {
+	points = mdb.ValidPoints(points)
+	coords := mdb.LatLngs(points)
+
+	result := &Result{
+		Scores: make([]Score, 0, 3),
+		Rules:  rules}
+
+	// Free distance through up to 3 turnpoints
+	dist, path := geo.FreeDistance(coords, 3, geo.Vincenty)
+	result.Scores = append(result.Scores, makeScore(FreeDistance, dist, 0, rules.FreeDistance, points, path))
+
+	// Closed flat and FAI triangles
+	perimeter, closing, corners := geo.ClosedTriangle(coords, false, rules.ClosingRatio, geo.Vincenty)
+	result.Scores = append(result.Scores, makeScore(FlatTriangle, perimeter-closing, closing, rules.FlatTriangle, points, corners))
+
+	perimeter, closing, corners = geo.ClosedTriangle(coords, true, rules.ClosingRatio, geo.Vincenty)
+	result.Scores = append(result.Scores, makeScore(FAITriangle, perimeter-closing, closing, rules.FAITriangle, points, corners))
+
+	for _, score := range result.Scores {
+		if score.Points > result.Points {
+			result.Best = score.Type
+			result.Points = score.Points
+		}
+	}
+	return result
+}
+
+func makeScore(scoreType string, dist, closing, multiplier float64, points []mdb.Point, idx []int) Score {
+	score := Score{
+		Type:            scoreType,
+		Distance:        mdb.Round2(dist / 1000),
+		ClosingDistance: mdb.Round2(closing / 1000),
+		Multiplier:      multiplier,
+		Turnpoints:      make([]Turnpoint, 0, len(idx))}
+	score.Points = mdb.Round2(score.Distance * multiplier)
+
+	for _, i := range idx {
+		score.Turnpoints = append(score.Turnpoints, Turnpoint{
+			Time: points[i].Time,
+			Lat:  points[i].Lat,
+			Lng:  points[i].Lng})
+	}
+	return score
+}
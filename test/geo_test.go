@@ -0,0 +1,47 @@
+package test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/haakonleg/imt2681-assig2/geo"
+)
+
+func TestDistanceFormulas(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestDistanceFormulas...")
+
+	// Flinders Peak to Buninyong, the example used by Vincenty
+	a := geo.LatLng{Lat: -37.95103341666667, Lng: 144.42486788888888}
+	b := geo.LatLng{Lat: -37.65282113888889, Lng: 143.92649552777777}
+
+	if d := geo.Vincenty(a, b); math.Abs(d-54972.271) > 0.01 {
+		t.Fatalf("Expected Vincenty distance: 54972.271. Got: %f", d)
+	}
+	if d := geo.Haversine(a, b); math.Abs(d-54972.271) > 200 {
+		t.Fatalf("Expected haversine distance close to 54972.271. Got: %f", d)
+	}
+	if d := geo.Vincenty(a, a); d != 0 {
+		t.Fatalf("Expected distance 0 between the same points. Got: %f", d)
+	}
+}
+
+func TestOptimizers(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestOptimizers...")
+
+	// An out and return flight along the equator, with a detour to the north
+	points := []geo.LatLng{{Lat: 0, Lng: 0}, {Lat: 0, Lng: 0.5}, {Lat: 0, Lng: 1}, {Lat: 0.5, Lng: 0.5}, {Lat: 0, Lng: 0}}
+	expect := geo.Haversine(points[0], points[2]) + geo.Haversine(points[2], points[3]) + geo.Haversine(points[3], points[4])
+
+	d, path := geo.FreeDistance(points, 3, geo.Haversine)
+	if math.Abs(d-expect) > 1 || path[0] != 0 || path[len(path)-1] != 4 {
+		t.Fatalf("Expected free distance: %f. Got: %f through %v", expect, d, path)
+	}
+
+	d, corners := geo.Triangle(points, true, geo.Haversine)
+	if len(corners) != 3 || corners[0] != 0 || corners[1] != 2 || corners[2] != 3 {
+		t.Fatalf("Unexpected FAI triangle: %f with corners %v", d, corners)
+	}
+}
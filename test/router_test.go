@@ -0,0 +1,612 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/router"
+)
+
+// record returns middleware that appends its name to order before calling the next handler
+func record(name string, order *[]string) router.Middleware {
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(req *router.Request) {
+			*order = append(*order, name)
+			next(req)
+		}
+	}
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestRouterMiddleware...")
+
+	var order []string
+	r := router.NewRouter()
+	r.Use(record("first", &order), record("second", &order))
+	r.Handle("GET", "/plain", func(req *router.Request) {
+		order = append(order, "handler")
+		req.SendText("ok", http.StatusOK)
+	})
+	r.Handle("GET", "/wrapped", func(req *router.Request) {
+		order = append(order, "handler")
+		req.SendText("ok", http.StatusOK)
+	}, record("route", &order))
+	r.Handle("GET", "/panic", func(req *router.Request) {
+		panic("handler failed")
+	}, router.Recover)
+
+	// Global middleware runs in the order it was registered, before the route middleware
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/wrapped", nil))
+	expect := "[first second route handler]"
+	if fmt.Sprint(order) != expect {
+		t.Fatalf("Expected middleware order %s. Got: %v", expect, order)
+	}
+
+	// Route middleware is only used for its own route
+	order = nil
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/plain", nil))
+	expect = "[first second handler]"
+	if fmt.Sprint(order) != expect {
+		t.Fatalf("Expected middleware order %s. Got: %v", expect, order)
+	}
+
+	// Global middleware also runs when no route matches
+	order = nil
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/missing", nil))
+	if fmt.Sprint(order) != "[first second]" || rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected global middleware and 404 for unknown route. Got: %v and %d", order, rec.Code)
+	}
+
+	// Panics are recovered and sent as 500
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/panic", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status code 500 after panic. Got: %d", rec.Code)
+	}
+}
+
+func TestRouterMethods(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestRouterMethods...")
+
+	r := router.NewRouter()
+	r.EnableCORS(router.CORSOptions{AllowedOrigins: []string{"https://example.com"}, MaxAge: 600})
+	r.Handle("GET", "/tracks", func(req *router.Request) {
+		req.SendText("tracks", http.StatusOK)
+	})
+	r.Handle("POST", "/tracks", func(req *router.Request) {
+		req.SendText("created", http.StatusOK)
+	})
+
+	// Unregistered methods on an existing path get 405 with the allowed methods
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("DELETE", "/tracks", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD, OPTIONS, POST" {
+		t.Fatalf("Expected 405 with Allow header. Got: %d %q", rec.Code, rec.Header().Get("Allow"))
+	}
+
+	// HEAD is served by the GET handler without a body
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("HEAD", "/tracks", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Fatalf("Expected 200 without body for HEAD. Got: %d %q", rec.Code, rec.Body.String())
+	}
+
+	// OPTIONS is answered automatically, with the CORS headers for preflight requests from allowed origins
+	req := httptest.NewRequest("OPTIONS", "/tracks", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://example.com" ||
+		rec.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD, OPTIONS, POST" {
+		t.Fatalf("Unexpected preflight response: %d %v", rec.Code, rec.Header())
+	}
+
+	// Other origins do not get CORS headers
+	req = httptest.NewRequest("GET", "/tracks", nil)
+	req.Header.Set("Origin", "https://other.com")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("Expected no CORS headers for other origins")
+	}
+}
+
+func TestRouterVariables(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestRouterVariables...")
+
+	var vars map[string]interface{}
+	handler := func(req *router.Request) {
+		vars = req.Vars
+		req.SendText("ok", http.StatusOK)
+	}
+
+	r := router.NewRouter()
+	r.Handle("GET", "/track/{id:objectid}", handler)
+	r.Handle("GET", "/track/{id:objectid}/{field:enum(pilot,glider)}", handler)
+	r.Handle("GET", "/ticker/{ts:int}", handler)
+	r.Handle("GET", "/webhook/{name}", handler)
+	r.Handle("GET", "/files/{id}/meta", handler)
+	r.Handle("GET", "/files/{path...}", handler)
+
+	tests := []struct {
+		path   string
+		status int
+		vars   string
+	}{
+		{"/track/5bd1c3b2a1f1c5e3d4b2a1f0", http.StatusOK, "map[id:5bd1c3b2a1f1c5e3d4b2a1f0]"},
+		{"/track/xyz", http.StatusNotFound, ""},
+		{"/track/5bd1c3b2a1f1c5e3d4b2a1f0/glider", http.StatusOK, "map[field:glider id:5bd1c3b2a1f1c5e3d4b2a1f0]"},
+		{"/track/5bd1c3b2a1f1c5e3d4b2a1f0/track_length", http.StatusNotFound, ""},
+		{"/ticker/1540000000000", http.StatusOK, "map[ts:1540000000000]"},
+		{"/ticker/latest", http.StatusNotFound, ""},
+		{"/webhook/xyz", http.StatusOK, "map[name:xyz]"},
+		{"/files/a/meta", http.StatusOK, "map[id:a]"},
+		{"/files/a", http.StatusOK, "map[path:a]"},
+		{"/files/a/b/c", http.StatusOK, "map[path:a/b/c]"},
+	}
+	for _, test := range tests {
+		vars = nil
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.status {
+			t.Fatalf("Expected status code %d for %s. Got: %d", test.status, test.path, rec.Code)
+		}
+		if test.vars != "" && fmt.Sprint(vars) != test.vars {
+			t.Fatalf("Expected variables %s for %s. Got: %v", test.vars, test.path, vars)
+		}
+	}
+
+	// A path that the constraints reject does not exist for other methods either
+	for _, test := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"DELETE", "/track/xyz", http.StatusNotFound},
+		{"OPTIONS", "/track/xyz", http.StatusNotFound},
+		{"DELETE", "/track/5bd1c3b2a1f1c5e3d4b2a1f0", http.StatusMethodNotAllowed},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+		if rec.Code != test.status {
+			t.Fatalf("Expected status code %d for %s %s. Got: %d", test.status, test.method, test.path, rec.Code)
+		}
+	}
+
+	// The int constraint decodes the variable
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ticker/42", nil))
+	if ts, ok := vars["ts"].(int64); !ok || ts != 42 {
+		t.Fatalf("Expected ts to be decoded to int64. Got: %#v", vars["ts"])
+	}
+
+	// Conflicting and invalid routes are rejected when they are registered
+	for _, pattern := range []string{"/track/{key}", "/ticker/{ts:float}", "/files/{path...}/meta", "/a/{x}/{x}"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Expected registering %s to panic", pattern)
+				}
+			}()
+			r.Handle("GET", pattern, handler)
+		}()
+	}
+}
+
+func TestRouterBindQuery(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestRouterBindQuery...")
+
+	type query struct {
+		Name  string    `query:"name"`
+		Limit int64     `query:"limit" default:"10" min:"1" max:"100"`
+		Ratio float64   `query:"ratio"`
+		Desc  bool      `query:"desc"`
+		Sort  string    `query:"sort" default:"ts" enum:"ts,length"`
+		Date  time.Time `query:"date" layout:"2006-01-02"`
+	}
+
+	bind := func(rawQuery string) (*query, *router.Error) {
+		q := new(query)
+		req := &router.Request{R: httptest.NewRequest("GET", "/?"+rawQuery, nil)}
+		return q, req.BindQuery(q)
+	}
+
+	// Valid parameters are decoded, and defaults are used for missing parameters
+	q, rErr := bind("name=Pascal+GENIN&ratio=0.5&desc=true&date=2017-08-07")
+	if rErr != nil {
+		t.Fatalf("Expected no error. Got: %+v", rErr)
+	}
+	expect := query{Name: "Pascal GENIN", Limit: 10, Ratio: 0.5, Desc: true, Sort: "ts", Date: time.Date(2017, 8, 7, 0, 0, 0, 0, time.UTC)}
+	if *q != expect {
+		t.Fatalf("Expected %+v. Got: %+v", expect, *q)
+	}
+
+	// Every invalid parameter is listed in the error
+	_, rErr = bind("limit=0&ratio=half&sort=pilot&date=yesterday&name=ok")
+	if rErr == nil || rErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 error. Got: %+v", rErr)
+	}
+	names := make([]string, 0)
+	for _, param := range rErr.Params {
+		names = append(names, param.Name)
+	}
+	if fmt.Sprint(names) != "[limit ratio sort date]" {
+		t.Fatalf("Expected limit, ratio, sort and date to be invalid. Got: %+v", rErr.Params)
+	}
+}
+
+func TestRouterURLFor(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestRouterURLFor...")
+
+	handler := func(req *router.Request) {}
+	r := router.NewRouter()
+	r.Handle("GET", "/api/track", handler).Name("tracks")
+	r.Handle("GET", "/api/track/{id:objectid}/{field}", handler).Name("track_field")
+	r.Handle("GET", "/api/ticker/{ts:int}", handler).Name("ticker")
+	r.Handle("GET", "/files/{path...}", handler).Name("files")
+
+	tests := []struct {
+		name   string
+		vars   map[string]interface{}
+		expect string
+	}{
+		{"tracks", nil, "/api/track"},
+		{"track_field", map[string]interface{}{"id": "5bd1c3b2a1f1c5e3d4b2a1f0", "field": "a b/c"}, "/api/track/5bd1c3b2a1f1c5e3d4b2a1f0/a%20b%2Fc"},
+		{"ticker", map[string]interface{}{"ts": int64(1540000000000)}, "/api/ticker/1540000000000"},
+		{"files", map[string]interface{}{"path": "a/b c"}, "/files/a/b%20c"},
+	}
+	for _, test := range tests {
+		path, err := r.URLFor(test.name, test.vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != test.expect {
+			t.Fatalf("Expected URL %s for %s. Got: %s", test.expect, test.name, path)
+		}
+	}
+
+	// Unknown routes, missing variables and values that do not meet the constraints are errors
+	if _, err := r.URLFor("missing", nil); err == nil {
+		t.Fatal("Expected error for unknown route name")
+	}
+	if _, err := r.URLFor("track_field", map[string]interface{}{"id": "5bd1c3b2a1f1c5e3d4b2a1f0"}); err == nil {
+		t.Fatal("Expected error for missing variable")
+	}
+	if _, err := r.URLFor("ticker", map[string]interface{}{"ts": "latest"}); err == nil {
+		t.Fatal("Expected error for variable that does not meet the constraint")
+	}
+}
+
+func TestRouterGroup(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestRouterGroup...")
+
+	var order []string
+	handler := func(req *router.Request) {
+		order = append(order, "handler")
+		req.SendText("ok", http.StatusOK)
+	}
+
+	r := router.NewRouter()
+	r.Use(record("router", &order))
+	api := r.Group("/api/", record("api", &order))
+	v2 := api.Group("v2", record("v2", &order))
+	api.Handle("GET", "/track", handler).Name("tracks")
+	v2.Handle("GET", "/track", handler, record("route", &order)).Name("tracks_v2")
+	r.Handle("GET", "/other", handler)
+
+	// Middleware registered on a group after its routes is also used
+	api.Use(record("late", &order))
+
+	tests := []struct {
+		path  string
+		order string
+	}{
+		{"/api/track", "[router api late handler]"},
+		{"/api/v2/track", "[router api late v2 route handler]"},
+		{"/other", "[router handler]"},
+	}
+	for _, test := range tests {
+		order = nil
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != http.StatusOK || fmt.Sprint(order) != test.order {
+			t.Fatalf("Expected 200 and middleware order %s for %s. Got: %d %v", test.order, test.path, rec.Code, order)
+		}
+	}
+
+	// URLs to routes in groups include the prefix
+	if path, err := r.URLFor("tracks_v2", nil); err != nil || path != "/api/v2/track" {
+		t.Fatalf("Expected URL /api/v2/track. Got: %s %v", path, err)
+	}
+}
+
+func TestRouterJSON(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestRouterJSON...")
+
+	// Values that can not be encoded are sent as 500 instead of stopping the server
+	rec := httptest.NewRecorder()
+	req := &router.Request{W: rec, R: httptest.NewRequest("GET", "/", nil)}
+	if err := req.SendJSON(map[string]interface{}{"ch": make(chan int)}, http.StatusOK); err == nil {
+		t.Fatal("Expected error when encoding a channel")
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status code 500. Got: %d", rec.Code)
+	}
+
+	type body struct {
+		URL   string `json:"url"`
+		Count int    `json:"count"`
+	}
+	tests := []struct {
+		body   string
+		kind   router.JSONErrorKind
+		status int
+	}{
+		{"", router.JSONEmpty, http.StatusBadRequest},
+		{`{"url": "a"`, router.JSONSyntax, http.StatusBadRequest},
+		{`{"url": "a"} {}`, router.JSONSyntax, http.StatusBadRequest},
+		{`{"url": "a", "count": "one"}`, router.JSONType, http.StatusBadRequest},
+		{`{"url": "a", "extra": 1}`, router.JSONUnknownField, http.StatusBadRequest},
+		{`{"url": "` + strings.Repeat("a", router.MaxJSONSize) + `"}`, router.JSONTooLarge, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		req := &router.Request{W: httptest.NewRecorder(), R: httptest.NewRequest("POST", "/", strings.NewReader(test.body))}
+		err := req.ParseJSONRequest(new(body))
+		jErr, ok := err.(*router.JSONError)
+		if !ok || jErr.Kind != test.kind || jErr.RouterError().StatusCode != test.status {
+			t.Fatalf("Expected JSON error kind %d with status %d. Got: %v", test.kind, test.status, err)
+		}
+	}
+
+	// Valid bodies are decoded
+	req = &router.Request{W: httptest.NewRecorder(), R: httptest.NewRequest("POST", "/", strings.NewReader(`{"url": "a", "count": 2}`))}
+	decoded := new(body)
+	if err := req.ParseJSONRequest(decoded); err != nil || decoded.URL != "a" || decoded.Count != 2 {
+		t.Fatalf("Expected body to be decoded. Got: %+v %v", decoded, err)
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestProblemResponses...")
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{"GET", "/paragliding/api/missing", http.StatusNotFound, router.CodeNotFound},
+		{"DELETE", "/paragliding/api/track", http.StatusMethodNotAllowed, router.CodeMethodNotAllowed},
+		{"GET", "/paragliding/api/track/000000000000000000000000", http.StatusBadRequest, router.CodeInvalidID},
+		{"GET", "/paragliding/api/track?limit=0", http.StatusBadRequest, router.CodeInvalidParameters},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, "http://:"+listenPort+test.path, nil)
+		req.Header.Set(router.RequestIDHeader, "test-request")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		problem := new(router.Error)
+		err = json.NewDecoder(resp.Body).Decode(problem)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != test.status || resp.Header.Get("Content-Type") != router.ProblemContentType {
+			t.Fatalf("Expected %d %s for %s %s. Got: %d %s", test.status, router.ProblemContentType, test.method, test.path,
+				resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if problem.Code != test.code || problem.StatusCode != test.status || problem.Title != http.StatusText(test.status) ||
+			problem.Instance != "test-request" {
+			t.Fatalf("Unexpected problem for %s %s: %+v", test.method, test.path, problem)
+		}
+	}
+}
+
+// csvValue is a value that can be sent as CSV
+type csvValue struct {
+	A int    `json:"a"`
+	B []bool `json:"b"`
+	C string `json:"c"`
+}
+
+func (v csvValue) MarshalCSV() [][]string {
+	return [][]string{{"a", "c"}, {fmt.Sprint(v.A), v.C}}
+}
+
+func (v csvValue) String() string {
+	return v.C
+}
+
+func TestRouterRespond(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestRouterRespond...")
+
+	value := csvValue{A: 1, B: []bool{true, false}, C: "x"}
+	offers := []router.ResponseType{router.JSON, router.CSV, router.MSGPACK, router.TEXT}
+
+	tests := []struct {
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"", http.StatusOK, "application/json", `{"a":1,"b":[true,false],"c":"x"}`},
+		{"*/*", http.StatusOK, "application/json", `{"a":1,"b":[true,false],"c":"x"}`},
+		{"text/csv", http.StatusOK, "text/csv", "a,c\n1,x\n"},
+		{"text/*;q=0.5, text/plain", http.StatusOK, "text/plain", "x"},
+		{"application/json;q=0.1, application/x-msgpack", http.StatusOK, "application/msgpack", "\x83\xa1a\x01\xa1b\x92\xc3\xc2\xa1c\xa1x"},
+		{"application/xml, application/json;q=0", http.StatusNotAcceptable, router.ProblemContentType, ""},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req := &router.Request{W: rec, R: httptest.NewRequest("GET", "/", nil)}
+		if test.accept != "" {
+			req.R.Header.Set("Accept", test.accept)
+		}
+		req.Respond(value, http.StatusOK, offers...)
+
+		if rec.Code != test.status || rec.Header().Get("Content-Type") != test.contentType {
+			t.Fatalf("Expected %d %s for Accept %q. Got: %d %s", test.status, test.contentType, test.accept, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Fatalf("Expected body %q for Accept %q. Got: %q", test.body, test.accept, rec.Body.String())
+		}
+	}
+}
+
+func TestRouterNotModified(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestRouterNotModified...")
+
+	etag := `"abc"`
+	modified := time.Date(2018, 10, 1, 12, 0, 0, 500, time.UTC)
+
+	tests := []struct {
+		header string
+		value  string
+		status int
+	}{
+		{"", "", http.StatusOK},
+		{"If-None-Match", `"abc"`, http.StatusNotModified},
+		{"If-None-Match", `"xyz", W/"abc"`, http.StatusNotModified},
+		{"If-None-Match", "*", http.StatusNotModified},
+		{"If-None-Match", `"xyz"`, http.StatusOK},
+		{"If-Modified-Since", modified.Format(http.TimeFormat), http.StatusNotModified},
+		{"If-Modified-Since", modified.Add(-time.Second).Format(http.TimeFormat), http.StatusOK},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req := &router.Request{W: rec, R: httptest.NewRequest("GET", "/", nil)}
+		if test.header != "" {
+			req.R.Header.Set(test.header, test.value)
+		}
+		if !req.NotModified(etag, modified) {
+			req.SendText("body", http.StatusOK)
+		}
+
+		if rec.Code != test.status {
+			t.Fatalf("Expected %d for %s: %s. Got: %d", test.status, test.header, test.value, rec.Code)
+		}
+		if rec.Header().Get("ETag") != etag || rec.Header().Get("Last-Modified") != modified.Format(http.TimeFormat) {
+			t.Fatalf("Expected ETag and Last-Modified headers. Got: %v", rec.Header())
+		}
+	}
+}
+
+func TestRouterCompression(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestRouterCompression...")
+
+	large := strings.Repeat(`{"lat":60.79,"lng":10.69},`, 100)
+	r := router.NewRouter()
+	r.EnableCompression(router.CompressionOptions{MinSize: 1024})
+	r.Handle("GET", "/large", func(req *router.Request) {
+		req.W.Header().Set("ETag", `"large"`)
+		req.SendData([]byte(large), "application/json", http.StatusOK)
+	})
+	r.Handle("GET", "/small", func(req *router.Request) {
+		req.SendText("small", http.StatusOK)
+	})
+	r.Handle("GET", "/binary", func(req *router.Request) {
+		req.SendData([]byte(large), "image/png", http.StatusOK)
+	})
+	r.Handle("POST", "/echo", func(req *router.Request) {
+		body, _ := ioutil.ReadAll(req.R.Body)
+		req.SendText(string(body), http.StatusOK)
+	}, router.Decompress)
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		encoding       string
+		etag           string
+	}{
+		{"/large", "gzip, deflate", "gzip", `"large-gzip"`},
+		{"/large", "gzip;q=0.5, deflate", "deflate", `"large-deflate"`},
+		{"/large", "br", "", `"large"`},
+		{"/large", "", "", `"large"`},
+		{"/small", "gzip", "", ""},
+		{"/binary", "gzip", "", ""},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		r.ServeHTTP(rec, req)
+
+		if rec.Header().Get("Content-Encoding") != test.encoding || rec.Header().Get("ETag") != test.etag {
+			t.Fatalf("Expected encoding %q and ETag %s for %s with Accept-Encoding %q. Got: %v", test.encoding, test.etag,
+				test.path, test.acceptEncoding, rec.Header())
+		}
+
+		var body io.Reader = rec.Body
+		var err error
+		switch test.encoding {
+		case "gzip":
+			body, err = gzip.NewReader(body)
+		case "deflate":
+			body, err = zlib.NewReader(body)
+		}
+		if err != nil {
+			t.Fatalf("Invalid %s body for %s: %v", test.encoding, test.path, err)
+		}
+		if b, err := ioutil.ReadAll(body); err != nil || (test.path == "/large" && string(b) != large) {
+			t.Fatalf("Unexpected body for %s with Accept-Encoding %q: %v", test.path, test.acceptEncoding, err)
+		}
+	}
+
+	// Gzip and zlib request bodies are decoded, and unsupported encodings are rejected
+	gz := new(bytes.Buffer)
+	zw := gzip.NewWriter(gz)
+	zw.Write([]byte("hello"))
+	zw.Close()
+	zl := new(bytes.Buffer)
+	lw := zlib.NewWriter(zl)
+	lw.Write([]byte("hello"))
+	lw.Close()
+
+	bodies := []struct {
+		encoding string
+		body     []byte
+		status   int
+	}{
+		{"gzip", gz.Bytes(), http.StatusOK},
+		{"gzip", []byte("hello"), http.StatusBadRequest},
+		{"deflate", zl.Bytes(), http.StatusOK},
+		{"deflate", []byte("hello"), http.StatusBadRequest},
+		{"br", []byte("hello"), http.StatusUnsupportedMediaType},
+	}
+	for _, test := range bodies {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/echo", bytes.NewReader(test.body))
+		req.Header.Set("Content-Encoding", test.encoding)
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != test.status || (test.status == http.StatusOK && rec.Body.String() != "hello") {
+			t.Fatalf("Expected %d for a %s request body. Got: %d %s", test.status, test.encoding, rec.Code, rec.Body.String())
+		}
+	}
+}
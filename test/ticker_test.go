@@ -13,14 +13,19 @@ func TestGetLatestTimestamp(t *testing.T) {
 	t.Parallel()
 	fmt.Println("Running test TestGetLatestTimestamp...")
 
+	// Make sure there is at least one track registered
+	if _, err := postTrack(igcURL("short-flight.igc")); err != nil {
+		t.Fatal(err)
+	}
+
 	var response string
 	if err := sendGetRequest("/paragliding/api/ticker/latest", &response, false); err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	ts, err := strconv.ParseInt(response, 10, 64)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	tm := time.Unix(ts/int64(1000), 0)
@@ -31,19 +36,24 @@ func TestGetTicker(t *testing.T) {
 	t.Parallel()
 	fmt.Println("Running test TestGetTicker...")
 
+	// Make sure there is at least one track registered
+	if _, err := postTrack(igcURL("short-flight.igc")); err != nil {
+		t.Fatal(err)
+	}
+
 	var response string
 	if err := sendGetRequest("/paragliding/api/ticker/latest", &response, false); err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	ts, err := strconv.ParseInt(response, 10, 64)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	ticker := new(ticker.GetTickerResponse)
 	if err := sendGetRequest("/paragliding/api/ticker", ticker, true); err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	if ticker.TLatest != ts {
@@ -52,7 +62,7 @@ func TestGetTicker(t *testing.T) {
 
 	tsTest := int64(1539381600000)
 	if err := sendGetRequest("/paragliding/api/ticker/1539381600000", ticker, true); err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	if ticker.TStart <= tsTest {
@@ -1,11 +1,26 @@
 package test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/haakonleg/imt2681-assig2/analysis"
+	"github.com/haakonleg/imt2681-assig2/geo"
 	"github.com/haakonleg/imt2681-assig2/mdb"
+	"github.com/haakonleg/imt2681-assig2/scoring"
 	"github.com/haakonleg/imt2681-assig2/track"
 )
 
@@ -15,21 +30,21 @@ func TestPostTrack(t *testing.T) {
 
 	// Register two tracks and retrieve IDs
 	var ids [2]string
-	res, err := postTrack("http://skypolaris.org/wp-content/uploads/IGS%20Files/Madrid%20to%20Jerez.igc")
+	res, err := postTrack(igcURL("short-flight.igc"))
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 	ids[0] = res.ID
-	res, err = postTrack("http://skypolaris.org/wp-content/uploads/IGS%20Files/Jarez%20to%20Senegal.igc")
+	res, err = postTrack(igcURL("long-flight.igc"))
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 	ids[1] = res.ID
 
 	// Get all track IDs
 	trackIDs, err := getAllTracks()
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	// The array should contain the two last track IDs registered
@@ -46,49 +61,112 @@ func TestGetTrack(t *testing.T) {
 	fmt.Println("Running test TestGetTrack...")
 
 	// Register a track
-	res, err := postTrack("http://skypolaris.org/wp-content/uploads/IGS%20Files/Madrid%20to%20Jerez.igc")
+	res, err := postTrack(igcURL("short-flight.igc"))
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	id := res.ID
 
 	track, err := getTrack(id)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// Check that the track matches the expected data
 	expect := &mdb.Track{
-		HDate:       "2016-02-19 00:00:00 +0000 UTC",
-		Pilot:       "Miguel Angel Gordillo",
-		Glider:      "RV8",
-		GliderID:    "EC-XLL",
-		TrackLength: "443.26km",
-		TrackSrcURL: "http://skypolaris.org/wp-content/uploads/IGS%20Files/Madrid%20to%20Jerez.igc"}
+		HDate:         "2017-08-09 00:00:00 +0000 UTC",
+		Pilot:         "Dijon Planeurs CDVV",
+		Glider:        "DG 500",
+		GliderID:      "F-CIED",
+		TrackLength:   76709.10,
+		DistanceModel: geo.ModelHaversine,
+		TrackSrcURL:   igcURL("short-flight.igc"),
+		FlightStats: mdb.FlightStats{
+			TakeoffTime:    time.Date(2017, 8, 9, 12, 12, 47, 0, time.UTC),
+			LandingTime:    time.Date(2017, 8, 9, 12, 52, 39, 0, time.UTC),
+			FlightDuration: 2392,
+			MaxPressureAlt: 1996,
+			MinPressureAlt: 453,
+			MaxGNSSAlt:     2097,
+			MinGNSSAlt:     522,
+			MaxClimb:       6.75,
+			MaxSink:        -7,
+			MaxSpeed:       180.24,
+			AvgSpeed:       111.11}}
 
 	if !reflect.DeepEqual(track, expect) {
 		t.Fatalf("Expected %v. Got: %v", expect, track)
 	}
 }
 
+func TestPostTrackDistanceModel(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestPostTrackDistanceModel...")
+
+	testCases := [][]string{
+		{"haversine", "76709.10"}, {"vincenty", "76803.79"},
+		{"free_distance", "45492.21"}, {"fai_triangle", "28915.49"}}
+
+	for _, testCase := range testCases {
+		res := new(track.PostTrackResponse)
+		request := &track.PostTrackRequest{URL: igcURL("short-flight.igc"), DistanceModel: testCase[0]}
+		if err := sendPostRequest("/paragliding/api/track", request, res); err != nil {
+			t.Fatal(err)
+		}
+
+		length, err := getTrackField(res.ID, "track_length")
+		if err != nil {
+			t.Fatal(err)
+		}
+		model, err := getTrackField(res.ID, "distance_model")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if length != testCase[1] || model != testCase[0] {
+			t.Fatalf("Expected: %s (%s). Got: %s (%s)", testCase[1], testCase[0], length, model)
+		}
+	}
+
+	// Unknown distance models should be rejected, before the IGC file is retrieved
+	var fetched int32
+	igcSource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		http.ServeFile(w, r, "testdata/short-flight.igc")
+	}))
+	defer igcSource.Close()
+
+	request := &track.PostTrackRequest{URL: igcSource.URL + "/short-flight.igc", DistanceModel: "flat_earth"}
+	if err := sendPostRequest("/paragliding/api/track", request, new(track.PostTrackResponse)); err == nil {
+		t.Fatalf("Expected unknown distance model to fail")
+	}
+	if n := atomic.LoadInt32(&fetched); n != 0 {
+		t.Fatalf("Expected the IGC file to not be retrieved for an unknown distance model. Retrieved %d times", n)
+	}
+}
+
 func TestGetTrackField(t *testing.T) {
 	t.Parallel()
 	fmt.Println("Running test TestGetTrackField...")
 
 	// Register a track
-	res, err := postTrack("http://skypolaris.org/wp-content/uploads/IGS%20Files/Madrid%20to%20Jerez.igc")
+	res, err := postTrack(igcURL("short-flight.igc"))
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 	id := res.ID
 
 	testCases := [][]string{
-		{"pilot", "Miguel Angel Gordillo"}, {"glider", "RV8"},
-		{"glider_id", "EC-XLL"}, {"track_length", "443.26km"},
-		{"H_date", "2016-02-19 00:00:00 +0000 UTC"}, {"track_src_url", "http://skypolaris.org/wp-content/uploads/IGS%20Files/Madrid%20to%20Jerez.igc"}}
+		{"pilot", "Dijon Planeurs CDVV"}, {"glider", "DG 500"},
+		{"glider_id", "F-CIED"}, {"track_length", "76709.10"},
+		{"H_date", "2017-08-09 00:00:00 +0000 UTC"}, {"track_src_url", igcURL("short-flight.igc")},
+		{"takeoff_time", "2017-08-09T12:12:47Z"}, {"flight_duration", "2392"},
+		{"max_gnss_alt", "2097"}, {"max_climb", "6.75"}, {"avg_speed", "111.11"}}
 
 	for _, testCase := range testCases {
 		res, err := getTrackField(id, testCase[0])
 		if err != nil {
-			t.Fatalf(err.Error())
+			t.Fatal(err)
 		}
 		if res != testCase[1] {
 			t.Fatalf("Expected: %s. Got: %s", testCase[1], res)
@@ -96,6 +174,497 @@ func TestGetTrackField(t *testing.T) {
 	}
 }
 
+func TestPostTrackUpload(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestPostTrackUpload...")
+
+	content, err := ioutil.ReadFile("testdata/short-flight.igc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Upload as raw request body
+	res := new(track.PostTrackResponse)
+	code, err := sendUploadRequest("/paragliding/api/track", "application/octet-stream", bytes.NewReader(content), res)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("Raw upload failed, status: %d, error: %v", code, err)
+	}
+	if pilot, err := getTrackField(res.ID, "pilot"); err != nil || pilot != "Dijon Planeurs CDVV" {
+		t.Fatalf("Expected: Dijon Planeurs CDVV. Got: %s (%v)", pilot, err)
+	}
+
+	// Upload as multipart/form-data
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	fw, _ := mw.CreateFormFile(track.IGCFormField, "short-flight.igc")
+	fw.Write(content)
+	mw.Close()
+
+	res = new(track.PostTrackResponse)
+	code, err = sendUploadRequest("/paragliding/api/track", mw.FormDataContentType(), body, res)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("Multipart upload failed, status: %d, error: %v", code, err)
+	}
+	if length, err := getTrackField(res.ID, "track_length"); err != nil || length != "76709.10" {
+		t.Fatalf("Expected: 76709.10. Got: %s (%v)", length, err)
+	}
+
+	// Upload a gzip compressed raw request body
+	gz := new(bytes.Buffer)
+	zw := gzip.NewWriter(gz)
+	zw.Write(content)
+	zw.Close()
+
+	req, _ := http.NewRequest("POST", "http://:"+listenPort+"/paragliding/api/track", gz)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = new(track.PostTrackResponse)
+	err = json.NewDecoder(resp.Body).Decode(res)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Gzip upload failed, status: %d, error: %v", resp.StatusCode, err)
+	}
+	if pilot, err := getTrackField(res.ID, "pilot"); err != nil || pilot != "Dijon Planeurs CDVV" {
+		t.Fatalf("Expected: Dijon Planeurs CDVV. Got: %s (%v)", pilot, err)
+	}
+
+	// Invalid content and too large files should be rejected
+	testCases := []struct {
+		body []byte
+		code int
+	}{
+		{[]byte("this is not an IGC file"), http.StatusBadRequest},
+		{[]byte{}, http.StatusBadRequest},
+		{bytes.Repeat(content, track.MaxIGCSize/len(content)+1), http.StatusRequestEntityTooLarge}}
+
+	for _, testCase := range testCases {
+		code, err := sendUploadRequest("/paragliding/api/track", "application/octet-stream", bytes.NewReader(testCase.body), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != testCase.code {
+			t.Fatalf("Expected status code: %d. Got: %d", testCase.code, code)
+		}
+	}
+}
+
+func TestGetTrackPoints(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestGetTrackPoints...")
+
+	res, err := postTrack(igcURL("short-flight.igc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/paragliding/api/track/" + res.ID + "/points"
+
+	// All points should be returned, with absolute timestamps
+	var points []mdb.Point
+	if err := sendGetRequest(path, &points, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 640 {
+		t.Fatalf("Expected 640 points. Got: %d", len(points))
+	}
+	first := points[0]
+	if first.Time.Format(time.RFC3339) != "2017-08-09T12:12:43Z" || first.PressureAlt != 1266 || first.GNSSAlt != 0 ||
+		math.Abs(first.Lat-47.3873) > 1e-4 || math.Abs(first.Lng-4.9482) > 1e-4 {
+		t.Fatalf("Unexpected first point: %+v", first)
+	}
+
+	// Decimation
+	points = nil
+	if err := sendGetRequest(path+"?step=10", &points, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 64 {
+		t.Fatalf("Expected 64 points. Got: %d", len(points))
+	}
+
+	// Time window
+	points = nil
+	if err := sendGetRequest(path+"?from=2017-08-09T12:30:00Z&to=2017-08-09T12:40:00Z", &points, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(points) == 0 {
+		t.Fatalf("Expected points within the time window")
+	}
+	for _, p := range points {
+		if p.Time.Hour() != 12 || p.Time.Minute() < 30 || p.Time.Minute() > 40 {
+			t.Fatalf("Point outside of time window: %v", p.Time)
+		}
+	}
+
+	// Invalid parameters
+	if err := sendGetRequest(path+"?step=0", &points, true); err == nil {
+		t.Fatalf("Expected invalid step to fail")
+	}
+}
+
+func TestGetTrackExport(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestGetTrackExport...")
+
+	res, err := postTrack(igcURL("short-flight.igc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://:" + listenPort + "/paragliding/api/track/" + res.ID + "/export"
+	const points = 640
+	const firstTime = "2017-08-09T12:12:43Z"
+
+	// GeoJSON is the default format
+	body := getExport(t, url, "application/geo+json", res.ID+".geojson")
+	feature := new(struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string      `json:"type"`
+			Coordinates [][]float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties struct {
+			Pilot      string   `json:"pilot"`
+			CoordTimes []string `json:"coordTimes"`
+		} `json:"properties"`
+	})
+	if err := json.Unmarshal(body, feature); err != nil {
+		t.Fatal(err)
+	}
+	if feature.Type != "Feature" || feature.Geometry.Type != "LineString" || feature.Properties.Pilot != "Dijon Planeurs CDVV" {
+		t.Fatalf("Unexpected GeoJSON feature: %s %s %s", feature.Type, feature.Geometry.Type, feature.Properties.Pilot)
+	}
+	coords := feature.Geometry.Coordinates
+	if len(coords) != points || len(feature.Properties.CoordTimes) != points {
+		t.Fatalf("Expected %d coordinates and times. Got: %d and %d", points, len(coords), len(feature.Properties.CoordTimes))
+	}
+	if len(coords[0]) != 3 || math.Abs(coords[0][0]-4.9482) > 1e-4 || math.Abs(coords[0][1]-47.3873) > 1e-4 ||
+		coords[0][2] != 1266 || feature.Properties.CoordTimes[0] != firstTime {
+		t.Fatalf("Unexpected first coordinate: %v %s", coords[0], feature.Properties.CoordTimes[0])
+	}
+
+	// KML, with a gx:Track that has a timestamp per fix
+	body = getExport(t, url+"?format=kml", "application/vnd.google-earth.kml+xml", res.ID+".kml")
+	kml := new(struct {
+		When   []string `xml:"Document>Placemark>Track>when"`
+		Coords []string `xml:"Document>Placemark>Track>coord"`
+	})
+	if err := xml.Unmarshal(body, kml); err != nil {
+		t.Fatal(err)
+	}
+	if len(kml.When) != points || len(kml.Coords) != points {
+		t.Fatalf("Expected %d KML timestamps and coordinates. Got: %d and %d", points, len(kml.When), len(kml.Coords))
+	}
+	if kml.When[0] != firstTime || !strings.HasPrefix(kml.Coords[0], "4.948") || !strings.HasSuffix(kml.Coords[0], " 1266") {
+		t.Fatalf("Unexpected first KML fix: %s %s", kml.When[0], kml.Coords[0])
+	}
+
+	// GPX, with a trkpt per fix
+	body = getExport(t, url+"?format=gpx", "application/gpx+xml", res.ID+".gpx")
+	gpx := new(struct {
+		Points []struct {
+			Lat  float64 `xml:"lat,attr"`
+			Lon  float64 `xml:"lon,attr"`
+			Ele  int64   `xml:"ele"`
+			Time string  `xml:"time"`
+		} `xml:"trk>trkseg>trkpt"`
+	})
+	if err := xml.Unmarshal(body, gpx); err != nil {
+		t.Fatal(err)
+	}
+	if len(gpx.Points) != points {
+		t.Fatalf("Expected %d GPX track points. Got: %d", points, len(gpx.Points))
+	}
+	first := gpx.Points[0]
+	if first.Time != firstTime || first.Ele != 1266 || math.Abs(first.Lat-47.3873) > 1e-4 || math.Abs(first.Lon-4.9482) > 1e-4 {
+		t.Fatalf("Unexpected first GPX track point: %+v", first)
+	}
+
+	// Unknown format
+	resp, err := http.Get(url + "?format=xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an unknown format. Got: %d", resp.StatusCode)
+	}
+}
+
+func TestGetTrackAnalysis(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestGetTrackAnalysis...")
+
+	res, err := postTrack(igcURL("short-flight.igc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := new(analysis.Analysis)
+	if err := sendGetRequest("/paragliding/api/track/"+res.ID+"/analysis", result, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Thermals) != 4 || len(result.Glides) != 4 {
+		t.Fatalf("Expected 4 thermals and 4 glides. Got: %d thermals and %d glides", len(result.Thermals), len(result.Glides))
+	}
+
+	// The flight starts circling after takeoff, and every thermal is followed by a glide
+	first := result.Thermals[0]
+	if first.EntryTime.Format(time.RFC3339) != "2017-08-09T12:12:47Z" || first.AltitudeGain != 548 || first.TurnDirection != "left" {
+		t.Fatalf("Unexpected first thermal: %+v", first)
+	}
+	for i, thermal := range result.Thermals {
+		if !thermal.ExitTime.Equal(result.Glides[i].StartTime) {
+			t.Fatalf("Expected glide %d to start when thermal %d ends", i, i)
+		}
+	}
+
+	last := result.Glides[3]
+	if last.EndTime.Format(time.RFC3339) != "2017-08-09T12:52:39Z" || last.GlideRatio == nil || *last.GlideRatio != 18.63 {
+		t.Fatalf("Unexpected last glide: %+v", last)
+	}
+}
+
+func TestGetTrackScore(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestGetTrackScore...")
+
+	res, err := postTrack(igcURL("short-flight.igc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := new(scoring.Result)
+	if err := sendGetRequest("/paragliding/api/track/"+res.ID+"/score", result, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Scores) != 3 {
+		t.Fatalf("Expected 3 scores. Got: %d", len(result.Scores))
+	}
+
+	// The flight is best scored as a flat triangle
+	expect := map[string]float64{
+		scoring.FreeDistance: 43.11,
+		scoring.FlatTriangle: 49.24,
+		scoring.FAITriangle:  38.26}
+	for _, score := range result.Scores {
+		if score.Points != expect[score.Type] {
+			t.Fatalf("Expected %s to score %.2f points. Got: %.2f", score.Type, expect[score.Type], score.Points)
+		}
+		if score.Type == scoring.FreeDistance && len(score.Turnpoints) > 5 {
+			t.Fatalf("Expected at most 5 points in free distance. Got: %d", len(score.Turnpoints))
+		}
+		if score.Type != scoring.FreeDistance && len(score.Turnpoints) != 3 {
+			t.Fatalf("Expected 3 corners in %s. Got: %d", score.Type, len(score.Turnpoints))
+		}
+	}
+	if result.Best != scoring.FlatTriangle || result.Points != 49.24 {
+		t.Fatalf("Expected best score to be flat_triangle with 49.24 points. Got: %s with %.2f points", result.Best, result.Points)
+	}
+}
+
+func TestGetTrackConditional(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestGetTrackConditional...")
+
+	res, err := postTrack(igcURL("short-flight.igc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://:" + listenPort + "/paragliding/api/track/" + res.ID
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || resp.Header.Get("Last-Modified") == "" ||
+		resp.Header.Get("Cache-Control") != "no-cache" {
+		t.Fatalf("Expected 200 with caching headers. Got: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// The same track should not be sent again
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified || resp.Header.Get("ETag") != etag {
+		t.Fatalf("Expected 304 with ETag %s. Got: %d %s", etag, resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	// Accept headers that negotiate the same response type give the same entity tag
+	for _, accept := range []string{"application/json", "application/json, */*;q=0.1", "*/*"} {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("If-None-Match", etag)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotModified {
+			t.Fatalf("Expected 304 with Accept %q. Got: %d", accept, resp.StatusCode)
+		}
+	}
+
+	// Other representations of the track have other entity tags
+	req, _ = http.NewRequest("GET", url+"/pilot", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Fatalf("Expected 200 with a different ETag for the pilot field. Got: %d %s", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+}
+
+func TestGetAllTracksFilter(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestGetAllTracksFilter...")
+
+	// Register two tracks by the same pilot, and one by another pilot
+	first, err := postTrack(igcURL("long-flight.igc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := postTrack(igcURL("long-flight.igc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := postTrack(igcURL("short-flight.igc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Walk through the tracks by the pilot one at a time, newest first
+	ids := make([]string, 0)
+	next := "/paragliding/api/track?pilot=Pascal+GENIN&date_from=2017-08-07&date_to=2017-08-07&min_length=100000&sort=-ts&limit=1"
+	for next != "" {
+		page, link, err := getTrackPage(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 1 {
+			t.Fatalf("Expected at most 1 track in page. Got: %d", len(page))
+		}
+		for _, id := range page {
+			if isInArr(ids, id) {
+				t.Fatalf("Track %s was returned twice", id)
+			}
+			ids = append(ids, id)
+		}
+		next = link
+	}
+
+	if isInArr(ids, other.ID) {
+		t.Fatal("Expected tracks by other pilots to be filtered out")
+	}
+	if !isInArr(ids, first.ID) || !isInArr(ids, second.ID) {
+		t.Fatal("Expected both tracks by the pilot to be found")
+	}
+	for _, id := range ids {
+		if id == first.ID {
+			t.Fatal("Expected the newest track to be returned first")
+		}
+		if id == second.ID {
+			break
+		}
+	}
+
+	// Invalid query parameters are rejected
+	for _, query := range []string{"limit=0", "sort=pilot", "date_from=yesterday", "min_length=long", "cursor=000000000000000000000000"} {
+		resp, err := http.Get("http://:" + listenPort + "/paragliding/api/track?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status code 400 for %s. Got: %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestGetAllTracksPaging(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestGetAllTracksPaging...")
+
+	// Register tracks with equal lengths, so that the pages are split between tracks with the same sort value
+	posted := make([]string, 0)
+	var from, to int64
+	for _, name := range []string{"short-flight.igc", "long-flight.igc", "short-flight.igc", "long-flight.igc", "short-flight.igc"} {
+		response, err := postTrack(igcURL(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		track, err := getTrack(response.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from == 0 {
+			from = track.Ts
+		}
+		to = track.Ts
+		posted = append(posted, response.ID)
+	}
+	query := fmt.Sprintf("ts_from=%d&ts_to=%d&sort=-track_length", from, to)
+
+	all, _, err := getTrackPage("/paragliding/api/track?" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every track is returned exactly once when following the links two tracks at a time
+	ids := make([]string, 0)
+	next := "/paragliding/api/track?" + query + "&limit=2"
+	for pages := 0; next != ""; pages++ {
+		if pages > len(all) {
+			t.Fatal("Expected the links to end")
+		}
+		page, link, err := getTrackPage(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range page {
+			if isInArr(ids, id) {
+				t.Fatalf("Track %s was returned twice", id)
+			}
+			ids = append(ids, id)
+		}
+		next = link
+	}
+
+	if len(ids) != len(all) {
+		t.Fatalf("Expected %d tracks. Got: %d", len(all), len(ids))
+	}
+	for _, id := range posted {
+		if !isInArr(ids, id) {
+			t.Fatalf("Expected track %s to be returned", id)
+		}
+	}
+
+	// A cursor can not be used with another sort order
+	_, link, err := getTrackPage("/paragliding/api/track?" + query + "&limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get("http://:" + listenPort + strings.Replace(link, "sort=-track_length", "sort=ts", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status code 400 for a cursor with another sort order. Got: %d", resp.StatusCode)
+	}
+}
+
 func postTrack(url string) (*track.PostTrackResponse, error) {
 	response := new(track.PostTrackResponse)
 	if err := sendPostRequest("/paragliding/api/track", &track.PostTrackRequest{URL: url}, response); err != nil {
@@ -120,6 +689,29 @@ func getAllTracks() ([]string, error) {
 	return response, nil
 }
 
+// getTrackPage gets a page of track IDs, and the path to the next page from the Link header (empty if there is none)
+func getTrackPage(path string) ([]string, string, error) {
+	resp, err := http.Get("http://:" + listenPort + path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s got status code %d", path, resp.StatusCode)
+	}
+
+	var ids []string
+	if err := json.NewDecoder(resp.Body).Decode(&ids); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if link := resp.Header.Get("Link"); link != "" {
+		next = link[strings.Index(link, "<")+1 : strings.Index(link, ">")]
+	}
+	return ids, next, nil
+}
+
 func getTrackField(id string, field string) (string, error) {
 	var response string
 	if err := sendGetRequest("/paragliding/api/track/"+id+"/"+field, &response, false); err != nil {
@@ -127,3 +719,27 @@ func getTrackField(id string, field string) (string, error) {
 	}
 	return response, nil
 }
+
+// getExport downloads a track export, checks its content type and file name, and returns the body
+func getExport(t *testing.T, url string, contentType string, filename string) []byte {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 for %s. Got: %d", url, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, contentType) {
+		t.Fatalf("Expected Content-Type %s. Got: %s", contentType, ct)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != "attachment; filename=\""+filename+"\"" {
+		t.Fatalf("Expected attachment %s. Got: %s", filename, cd)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
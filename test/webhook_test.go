@@ -0,0 +1,631 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/mdb"
+	"github.com/haakonleg/imt2681-assig2/router"
+	"github.com/haakonleg/imt2681-assig2/track"
+	"github.com/haakonleg/imt2681-assig2/webhook"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// delivery is a webhook delivery received by a test receiver
+type delivery struct {
+	id        string
+	signature string
+	body      []byte
+}
+
+// newReceiver starts a server that receives webhook deliveries and sends them on the returned channel
+func newReceiver() (*httptest.Server, chan delivery) {
+	deliveries := make(chan delivery, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		select {
+		case deliveries <- delivery{r.Header.Get(webhook.DeliveryHeader), r.Header.Get(webhook.SignatureHeader), body}:
+		default:
+		}
+	}))
+	return server, deliveries
+}
+
+// waitForDelivery registers a track, and waits for a delivery that is signed with secret
+// Deliveries triggered by the other tests may arrive first, so deliveries that are not signed with it are skipped
+func waitForDelivery(deliveries chan delivery, secret string) (*delivery, error) {
+	if _, err := postTrack(igcURL("short-flight.igc")); err != nil {
+		return nil, err
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case d := <-deliveries:
+			if webhook.VerifySignature(d.signature, d.id, d.body, secret, time.Now()) == nil {
+				return &d, nil
+			}
+		case <-timeout:
+			return nil, fmt.Errorf("no delivery signed with %s", secret)
+		}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestWebhookSignature...")
+
+	receiver, deliveries := newReceiver()
+	defer receiver.Close()
+
+	// Secrets that are too short are rejected
+	err := sendPostRequest("/paragliding/api/webhook/new_track", &webhook.PostWebhookRequest{
+		WebhookURL: receiver.URL, MinTriggerValue: 1, Secret: "short"}, new(webhook.PostWebhookResponse))
+	if err == nil {
+		t.Fatal("Expected a short secret to be rejected")
+	}
+
+	secret := "0123456789abcdef0123456789abcdef"
+	res := new(webhook.PostWebhookResponse)
+	err = sendPostRequest("/paragliding/api/webhook/new_track", &webhook.PostWebhookRequest{
+		WebhookURL: receiver.URL, MinTriggerValue: 1, Secret: secret}, res)
+	if err != nil || res.Secret != secret {
+		t.Fatalf("Expected the webhook to be registered with secret %s. Got: %s (%v)", secret, res.Secret, err)
+	}
+
+	d, err := waitForDelivery(deliveries, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.id == "" {
+		t.Fatal("Expected the delivery to have an ID")
+	}
+
+	// Changing the delivery, using another secret or replaying it later must fail the verification
+	if err := webhook.VerifySignature(d.signature, d.id, append(d.body, ' '), secret, time.Now()); err != webhook.ErrSignatureMismatch {
+		t.Fatalf("Expected %v for a changed body. Got: %v", webhook.ErrSignatureMismatch, err)
+	}
+	if err := webhook.VerifySignature(d.signature, "other", d.body, secret, time.Now()); err != webhook.ErrSignatureMismatch {
+		t.Fatalf("Expected %v for another delivery ID. Got: %v", webhook.ErrSignatureMismatch, err)
+	}
+	if err := webhook.VerifySignature(d.signature, d.id, d.body, "wrong-secret", time.Now()); err != webhook.ErrSignatureMismatch {
+		t.Fatalf("Expected %v for the wrong secret. Got: %v", webhook.ErrSignatureMismatch, err)
+	}
+	if err := webhook.VerifySignature(d.signature, d.id, d.body, secret, time.Now().Add(10*time.Minute)); err != webhook.ErrSignatureExpired {
+		t.Fatalf("Expected %v for an old delivery. Got: %v", webhook.ErrSignatureExpired, err)
+	}
+
+	// During the grace period, deliveries are signed with both secrets
+	rotated := new(webhook.RotateSecretResponse)
+	if err := sendPostRequest("/paragliding/api/webhook/new_track/"+res.ID+"/secret", nil, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Secret == "" || rotated.Secret == secret || rotated.PreviousSecretExpires <= time.Now().Unix()*1000 {
+		t.Fatalf("Unexpected response when rotating the secret: %+v", rotated)
+	}
+	if d, err = waitForDelivery(deliveries, rotated.Secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := webhook.VerifySignature(d.signature, d.id, d.body, secret, time.Now()); err != nil {
+		t.Fatalf("Expected the delivery to be signed with the previous secret. Got: %v", err)
+	}
+
+	// Without a grace period, only the new secret is used
+	previous := rotated.Secret
+	if err := sendPostRequest("/paragliding/api/webhook/new_track/"+res.ID+"/secret?grace_period=0", nil, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if d, err = waitForDelivery(deliveries, rotated.Secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := webhook.VerifySignature(d.signature, d.id, d.body, previous, time.Now()); err != webhook.ErrSignatureMismatch {
+		t.Fatalf("Expected the delivery to not be signed with the previous secret. Got: %v", err)
+	}
+}
+
+// waitForFinished waits until the oldest delivery to a webhook is delivered or dead, and returns it
+func waitForFinished(webhookID string) (*mdb.Delivery, error) {
+	timeout := time.After(5 * time.Second)
+	for {
+		deliveries := make([]*mdb.Delivery, 0)
+		if err := sendGetRequest("/paragliding/api/webhook/new_track/"+webhookID+"/deliveries", &deliveries, true); err != nil {
+			return nil, err
+		}
+		if n := len(deliveries); n > 0 && deliveries[n-1].Status != mdb.DeliveryPending {
+			return deliveries[n-1], nil
+		}
+
+		select {
+		case <-timeout:
+			return nil, fmt.Errorf("the deliveries to webhook %s were not finished", webhookID)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestWebhookRetries...")
+
+	// flaky fails the first two attempts of each delivery, broken fails all of them
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[r.Header.Get(webhook.DeliveryHeader)]++
+		if attempts[r.Header.Get(webhook.DeliveryHeader)] <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer flaky.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	flakyRes, brokenRes := new(webhook.PostWebhookResponse), new(webhook.PostWebhookResponse)
+	if err := sendPostRequest("/paragliding/api/webhook/new_track", &webhook.PostWebhookRequest{WebhookURL: flaky.URL, MinTriggerValue: 1}, flakyRes); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendPostRequest("/paragliding/api/webhook/new_track", &webhook.PostWebhookRequest{WebhookURL: broken.URL, MinTriggerValue: 1}, brokenRes); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := postTrack(igcURL("short-flight.igc")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The flaky webhook gets the delivery on the third attempt
+	delivery, err := waitForFinished(flakyRes.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != mdb.DeliveryDelivered || len(delivery.Attempts) != 3 || delivery.Attempts[2].StatusCode != http.StatusOK {
+		t.Fatalf("Expected the delivery to succeed on the third attempt. Got: %+v", delivery)
+	}
+	for _, attempt := range delivery.Attempts[:2] {
+		if attempt.StatusCode != http.StatusInternalServerError || attempt.Error == "" {
+			t.Fatalf("Expected the first attempts to fail with status 500. Got: %+v", attempt)
+		}
+	}
+
+	// The broken webhook gives up after the maximum amount of attempts
+	delivery, err = waitForFinished(brokenRes.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != mdb.DeliveryDead || len(delivery.Attempts) != 3 || delivery.NextAttempt != 0 {
+		t.Fatalf("Expected the delivery to be dead after 3 attempts. Got: %+v", delivery)
+	}
+	if delivery.Attempts[1].Time < delivery.Attempts[0].Time+25 {
+		t.Fatalf("Expected a delay between the attempts. Got: %+v", delivery.Attempts)
+	}
+}
+
+func TestWebhookSlowReceiver(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestWebhookSlowReceiver...")
+
+	// The receiver hangs until the test is done
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer receiver.Close()
+	defer close(release)
+
+	res := new(webhook.PostWebhookResponse)
+	if err := sendPostRequest("/paragliding/api/webhook/new_track", &webhook.PostWebhookRequest{WebhookURL: receiver.URL, MinTriggerValue: 1}, res); err != nil {
+		t.Fatal(err)
+	}
+
+	// Registering tracks must not wait for the webhook
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := postTrack(igcURL("short-flight.igc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the tracks to be registered without waiting for the webhook. Took: %s", elapsed)
+	}
+
+	// The requests to the webhook time out
+	timeout := time.After(5 * time.Second)
+	for {
+		deliveries := make([]*mdb.Delivery, 0)
+		if err := sendGetRequest("/paragliding/api/webhook/new_track/"+res.ID+"/deliveries", &deliveries, true); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(deliveries); n > 0 && len(deliveries[n-1].Attempts) > 0 {
+			attempt := deliveries[n-1].Attempts[0]
+			if attempt.StatusCode != 0 || attempt.Error == "" || attempt.Duration > 2000 {
+				t.Fatalf("Expected the first attempt to time out. Got: %+v", attempt)
+			}
+			return
+		}
+
+		select {
+		case <-timeout:
+			t.Fatal("Expected an attempt to be recorded")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestWebhookShutdown(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestWebhookShutdown...")
+
+	var mu sync.Mutex
+	received := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		received++
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	// A dispatcher with its own storage, which only sends one delivery at a time and never polls
+	db := mdb.NewMemoryDatabase()
+	hook := mdb.CreateWebhook(receiver.URL, 1, "")
+	if _, err := db.InsertWebhook(&hook); err != nil {
+		t.Fatal(err)
+	}
+	wh := webhook.NewWebhookHandler(db)
+	policy := webhook.DefaultDeliveryPolicy
+	policy.PollInterval = time.Hour
+	policy.Workers = 1
+	wh.SetDeliveryPolicy(policy)
+	wh.Start()
+
+	for i := 0; i < 3; i++ {
+		db.InsertTrack(&mdb.Track{ID: objectid.New(), WebhooksPending: true}, nil)
+	}
+	wh.Events() <- track.TrackRegistered{}
+
+	// Shutdown waits until the queued deliveries are sent, and can be called again
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if err := wh.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Events sent after shutdown are dropped by the sender
+	for i := 0; i < 3; i++ {
+		select {
+		case wh.Events() <- track.TrackRegistered{}:
+		default:
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	deliveries, _ := db.GetDeliveries(hook.ID.Hex(), 0)
+	if received != 3 || len(deliveries) != 3 {
+		t.Fatalf("Expected 3 deliveries before shutdown. Got: %d received, %d stored", received, len(deliveries))
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status != mdb.DeliveryDelivered {
+			t.Fatalf("Expected the delivery to be delivered. Got: %+v", delivery)
+		}
+	}
+}
+
+func TestWebhookStalledDispatcher(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestWebhookStalledDispatcher...")
+
+	receiver, _ := newReceiver()
+	defer receiver.Close()
+
+	db := mdb.NewMemoryDatabase()
+	hook := mdb.CreateWebhook(receiver.URL, 1, "")
+	if _, err := db.InsertWebhook(&hook); err != nil {
+		t.Fatal(err)
+	}
+
+	// Register tracks while nothing receives the events, like a dispatcher that is busy or stopped
+	th := track.NewTrackHandler(db)
+	th.SetTrackEvents(make(chan track.TrackRegistered))
+	r := router.NewRouter()
+	r.Handle("POST", "/track", th.PostTrack)
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		body, _ := json.Marshal(&track.PostTrackRequest{URL: igcURL("short-flight.igc")})
+		done := make(chan struct{})
+		go func() {
+			r.ServeHTTP(rec, httptest.NewRequest("POST", "/track", bytes.NewReader(body)))
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected registering a track to not wait for the dispatcher")
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected the track to be registered. Got: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	// The tracks are stored as pending, and counted when a dispatcher is started
+	wh := webhook.NewWebhookHandler(db)
+	policy := webhook.DefaultDeliveryPolicy
+	policy.PollInterval = time.Hour
+	policy.Workers = 1
+	wh.SetDeliveryPolicy(policy)
+	wh.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := wh.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, _ := db.GetDeliveries(hook.ID.Hex(), 0)
+	if len(deliveries) != 3 {
+		t.Fatalf("Expected a delivery for each of the 3 tracks. Got: %d", len(deliveries))
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status != mdb.DeliveryDelivered {
+			t.Fatalf("Expected the delivery to be delivered. Got: %+v", delivery)
+		}
+	}
+}
+
+func TestWebhookTriggerConcurrency(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestWebhookTriggerConcurrency...")
+
+	// Negative trigger values are rejected by the API
+	err := sendPostRequest("/paragliding/api/webhook/new_track", &webhook.PostWebhookRequest{
+		WebhookURL: "http://localhost/", MinTriggerValue: -5}, new(webhook.PostWebhookResponse))
+	if err == nil {
+		t.Fatal("Expected a negative minTriggerValue to be rejected")
+	}
+
+	// Decrement and fire the counters from many goroutines at once, like many servers sharing the storage
+	// A webhook created with a trigger value below 1 fires once per track, like a trigger value of 1
+	db := mdb.NewMemoryDatabase()
+	minTriggerValues := []int64{1, 3, 7, -5}
+	ids := make([]string, len(minTriggerValues))
+	for i, minTriggerValue := range minTriggerValues {
+		hook := mdb.CreateWebhook("http://localhost/", minTriggerValue, "")
+		ids[i], _ = db.InsertWebhook(&hook)
+	}
+
+	const tracks = 60
+	var mu sync.Mutex
+	fired := make(map[string]int64)
+	var wg sync.WaitGroup
+	for i := 0; i < tracks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.DecrementWebhookTriggers(&mdb.Track{})
+			for {
+				hook, err := db.FireTriggeredWebhook(0)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				fired[hook.ID.Hex()]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, minTriggerValue := range minTriggerValues {
+		if minTriggerValue < 1 {
+			minTriggerValue = 1
+		}
+		hook, _ := db.GetWebhook(ids[i])
+		if fired[ids[i]] != tracks/minTriggerValue || hook.TriggerCount != minTriggerValue-tracks%minTriggerValue {
+			t.Fatalf("Expected a webhook with minTriggerValue %d to fire %d times with %d left. Got: %d times with %d left",
+				minTriggerValue, tracks/minTriggerValue, minTriggerValue-tracks%minTriggerValue, fired[ids[i]], hook.TriggerCount)
+		}
+	}
+}
+
+func TestDeliveryClaim(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestDeliveryClaim...")
+
+	db := mdb.NewMemoryDatabase()
+	hook := mdb.CreateWebhook("http://localhost/", 1, "")
+	db.InsertWebhook(&hook)
+	delivery := mdb.CreateDelivery(&hook, "claim", []byte("{}"), 100)
+	db.InsertDelivery(&delivery)
+	later := mdb.CreateDelivery(&hook, "later", []byte("{}"), 500)
+	db.InsertDelivery(&later)
+
+	// Only the deliveries that are due are found
+	due, err := db.GetDueDeliveries(100, 10)
+	if err != nil || len(due) != 1 || due[0].DeliveryID != "claim" {
+		t.Fatalf("Expected only the due delivery to be found. Got: %+v %v", due, err)
+	}
+
+	// Only one of many servers claiming at once gets the due delivery
+	var claimed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := db.ClaimDelivery(due[0], 100, 200); err == nil {
+				atomic.AddInt32(&claimed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if claimed != 1 {
+		t.Fatalf("Expected the delivery to be claimed once. Got: %d", claimed)
+	}
+
+	// It can not be claimed again until the lease is over, or it is released
+	if _, err := db.ClaimDelivery(due[0], 199, 300); err != mdb.ErrNotFound {
+		t.Fatalf("Expected the delivery to be leased. Got: %v", err)
+	}
+	db.ReleaseDelivery(&mdb.Delivery{ID: delivery.ID, NextAttempt: 200}, 150)
+	claim, err := db.ClaimDelivery(due[0], 150, 300)
+	if err != nil || claim.DeliveryID != "claim" || claim.NextAttempt != 300 {
+		t.Fatalf("Expected the released delivery to be claimed with the new lease. Got: %+v %v", claim, err)
+	}
+
+	// Releasing with a lease that is over does nothing once the delivery has been attempted
+	claim.Status = mdb.DeliveryDelivered
+	db.AddDeliveryAttempt(claim, mdb.DeliveryAttempt{Time: 160, StatusCode: http.StatusOK})
+	db.ReleaseDelivery(&mdb.Delivery{ID: delivery.ID, NextAttempt: 300}, 150)
+	if _, err := db.ClaimDelivery(due[0], 1000, 1100); err != mdb.ErrNotFound {
+		t.Fatalf("Expected the delivered delivery to not be claimed. Got: %v", err)
+	}
+	due, err = db.GetDueDeliveries(1000, 10)
+	if err != nil || len(due) != 1 || due[0].DeliveryID != "later" {
+		t.Fatalf("Expected only the pending delivery to be due. Got: %+v %v", due, err)
+	}
+}
+
+func TestWebhookParallelTracks(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestWebhookParallelTracks...")
+
+	receiver, deliveries := newReceiver()
+	defer receiver.Close()
+
+	// Use the isolated server, so that only the tracks posted by this test trigger the webhook
+	baseURL := "http://:" + isolatedPort + "/paragliding/api"
+	body, _ := json.Marshal(&webhook.PostWebhookRequest{WebhookURL: receiver.URL, MinTriggerValue: 3})
+	resp, err := http.Post(baseURL+"/webhook/new_track", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the webhook to be registered. Got: %d", resp.StatusCode)
+	}
+
+	const tracks = 30
+	body, _ = json.Marshal(&track.PostTrackRequest{URL: igcURL("short-flight.igc")})
+	errs := make(chan error, tracks)
+	for i := 0; i < tracks; i++ {
+		go func() {
+			resp, err := http.Post(baseURL+"/track", "application/json", bytes.NewReader(body))
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					err = fmt.Errorf("got status code %d", resp.StatusCode)
+				}
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < tracks; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The webhook fires once for every 3 tracks, and no more
+	received := 0
+	timeout := time.After(5 * time.Second)
+	for received < tracks/3 {
+		select {
+		case <-deliveries:
+			received++
+		case <-timeout:
+			t.Fatalf("Expected %d deliveries. Got: %d", tracks/3, received)
+		}
+	}
+	select {
+	case <-deliveries:
+		t.Fatalf("Expected only %d deliveries", tracks/3)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWebhookFilter(t *testing.T) {
+	t.Parallel()
+	fmt.Println("Running test TestWebhookFilter...")
+
+	receiver, deliveries := newReceiver()
+	defer receiver.Close()
+
+	// Negative distances are rejected
+	err := sendPostRequest("/paragliding/api/webhook/new_track", &webhook.PostWebhookRequest{
+		WebhookURL: receiver.URL, Filter: mdb.WebhookFilter{MinDistance: -1}}, new(webhook.PostWebhookResponse))
+	if err == nil {
+		t.Fatal("Expected a negative minimum distance to be rejected")
+	}
+
+	// Only notify about long flights by Pascal Genin in an LS 6
+	res := new(webhook.PostWebhookResponse)
+	err = sendPostRequest("/paragliding/api/webhook/new_track", &webhook.PostWebhookRequest{
+		WebhookURL:      receiver.URL,
+		MinTriggerValue: 1,
+		Filter:          mdb.WebhookFilter{Pilots: []string{"  PASCAL genin ", "Someone Else"}, Glider: "ls 6", MinDistance: 100000}}, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := new(mdb.Webhook)
+	if err := sendGetRequest("/paragliding/api/webhook/new_track/"+res.ID, hook, true); err != nil {
+		t.Fatal(err)
+	}
+	expect := mdb.WebhookFilter{Pilots: []string{"pascal genin", "someone else"}, Glider: "ls 6", MinDistance: 100000}
+	if !reflect.DeepEqual(hook.Filter, expect) {
+		t.Fatalf("Expected filter %+v. Got: %+v", expect, hook.Filter)
+	}
+
+	short, err := postTrack(igcURL("short-flight.igc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	long, err := postTrack(igcURL("long-flight.igc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the delivery of the long flight, the deliveries must only contain matching tracks
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case d := <-deliveries:
+			ticker := new(struct {
+				Tracks []string `json:"tracks"`
+			})
+			if err := json.Unmarshal(d.body, ticker); err != nil {
+				t.Fatal(err)
+			}
+
+			found := false
+			for _, id := range ticker.Tracks {
+				if id == short.ID {
+					t.Fatal("Expected the short flight to not be sent to the webhook")
+				}
+				if pilot, err := getTrackField(id, "pilot"); err != nil || pilot != "Pascal GENIN" {
+					t.Fatalf("Expected only flights by Pascal GENIN. Got: %s (%v)", pilot, err)
+				}
+				found = found || id == long.ID
+			}
+			if found {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Expected a delivery with the long flight")
+		}
+	}
+}
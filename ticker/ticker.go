@@ -7,9 +7,12 @@ import (
 
 	"github.com/haakonleg/imt2681-assig2/mdb"
 	"github.com/haakonleg/imt2681-assig2/router"
-	"github.com/mongodb/mongo-go-driver/bson"
+)
 
-	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+// The error codes used by the ticker handlers, in addition to the ones in the router package
+const (
+	CodeNoTracks     = "no_tracks"
+	CodeNoMoreTracks = "no_more_tracks"
 )
 
 type GetTickerResponse struct {
@@ -20,34 +23,43 @@ type GetTickerResponse struct {
 	Processing int64    `json:"processing"`
 }
 
+// MarshalCSV returns the ticker as CSV records, with one row per track
+func (ticker *GetTickerResponse) MarshalCSV() [][]string {
+	records := make([][]string, 0, len(ticker.Tracks)+1)
+	records = append(records, []string{"t_latest", "t_start", "t_stop", "processing", "track"})
+	for _, id := range ticker.Tracks {
+		records = append(records, []string{
+			strconv.FormatInt(ticker.TLatest, 10),
+			strconv.FormatInt(ticker.TStart, 10),
+			strconv.FormatInt(ticker.TStop, 10),
+			strconv.FormatInt(ticker.Processing, 10),
+			id})
+	}
+	return records
+}
+
 type TickerHandler struct {
 	tickerLimit int64
-	db          *mdb.Database
+	db          mdb.Storage
 }
 
-func NewTickerHandler(tickerLimit int64, db *mdb.Database) *TickerHandler {
+func NewTickerHandler(tickerLimit int64, db mdb.Storage) *TickerHandler {
 	return &TickerHandler{
 		tickerLimit: tickerLimit,
 		db:          db}
 }
 
 // Finds the timestamp of the latest added track in the database
-func findLatestTimestamp(db *mdb.Database) (int64, *router.Error) {
-	// Sort by timestamp in decsending order, and limit to one result
-	findopts := []findopt.Find{
-		findopt.Sort(bson.NewDocument(bson.EC.Int64("ts", -1))),
-		findopt.Projection(bson.NewDocument(bson.EC.Int64("ts", 1))),
-		findopt.Limit(1)}
-
-	tracks := make([]*mdb.Track, 0)
-	if err := db.Find(mdb.TRACKS, nil, findopts, &tracks); err != nil {
-		return -1, &router.Error{StatusCode: http.StatusInternalServerError, Message: "Internal database error"}
+func findLatestTimestamp(db mdb.Storage) (int64, *router.Error) {
+	ts, err := db.LatestTimestamp()
+	if err == mdb.ErrNotFound {
+		return -1, &router.Error{StatusCode: http.StatusBadRequest, Code: CodeNoTracks, Message: "No tracks added yet"}
 	}
-	if len(tracks) < 1 {
-		return -1, &router.Error{StatusCode: http.StatusBadRequest, Message: "No tracks added yet"}
+	if err != nil {
+		return -1, &router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"}
 	}
 
-	return tracks[0].Ts, nil
+	return ts, nil
 }
 
 func (th *TickerHandler) GetLatestTimestamp(req *router.Request) {
@@ -57,10 +69,16 @@ func (th *TickerHandler) GetLatestTimestamp(req *router.Request) {
 		return
 	}
 
-	req.SendText(strconv.FormatInt(ts, 10), http.StatusOK)
+	req.Respond(ts, http.StatusOK, router.TEXT, router.JSON, router.MSGPACK)
+}
+
+func MakeTicker(db mdb.Storage, tickerLimit, timestampLimit int64) (*GetTickerResponse, *router.Error) {
+	return MakeFilteredTicker(db, tickerLimit, timestampLimit, nil)
 }
 
-func MakeTicker(db *mdb.Database, tickerLimit, timestampLimit int64) (*GetTickerResponse, *router.Error) {
+// MakeFilteredTicker makes a ticker of the tracks added after timestampLimit, like MakeTicker, but only with the
+// tracks that match is true for. If match is nil, all tracks are included
+func MakeFilteredTicker(db mdb.Storage, tickerLimit, timestampLimit int64, match func(*mdb.Track) bool) (*GetTickerResponse, *router.Error) {
 	ticker := new(GetTickerResponse)
 
 	// Measure time
@@ -75,23 +93,27 @@ func MakeTicker(db *mdb.Database, tickerLimit, timestampLimit int64) (*GetTicker
 	// Add latest timestamp to struct
 	ticker.TLatest = latestTs
 
-	// Sort by timestamp oldest first, set minimum value for timestamp, limit results to tickerLimit, if it is over 0
-	findopts := []findopt.Find{
-		findopt.Projection(bson.NewDocument(bson.EC.Int64("ts", 1))),
-		findopt.Sort(bson.NewDocument(bson.EC.Int64("ts", 1))),
-		findopt.Max(bson.NewDocument(bson.EC.Int64("ts", timestampLimit)))}
-
-	if tickerLimit <= 0 {
-		findopts = append(findopts, findopt.Limit(tickerLimit))
+	// Retrieve the tracks added after timestampLimit from DB, oldest first, limited to tickerLimit if it is over 0
+	// If the tracks are filtered, the limit is applied to the matching tracks
+	dbLimit := tickerLimit
+	if match != nil {
+		dbLimit = 0
 	}
-
-	// Retrieve the track timestamps from DB
-	tracks := make([]*mdb.Track, 0)
-	if err := db.Find(mdb.TRACKS, nil, findopts, &tracks); err != nil {
-		return nil, &router.Error{StatusCode: http.StatusInternalServerError, Message: "Internal database error"}
+	tracks, dbErr := db.GetTracksAfter(timestampLimit, dbLimit)
+	if dbErr != nil {
+		return nil, &router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"}
+	}
+	if match != nil {
+		matching := make([]*mdb.Track, 0, len(tracks))
+		for _, tr := range tracks {
+			if match(tr) && (tickerLimit <= 0 || int64(len(matching)) < tickerLimit) {
+				matching = append(matching, tr)
+			}
+		}
+		tracks = matching
 	}
 	if len(tracks) < 1 {
-		return nil, &router.Error{StatusCode: http.StatusBadRequest, Message: "No more tracks"}
+		return nil, &router.Error{StatusCode: http.StatusBadRequest, Code: CodeNoMoreTracks, Message: "No more tracks"}
 	}
 
 	// Add start and stop timestamps and IDs to struct
@@ -119,7 +141,15 @@ func (th *TickerHandler) GetTicker(req *router.Request) {
 	ticker, err := MakeTicker(th.db, th.tickerLimit, timestampLimit)
 	if err != nil {
 		req.SendError(err)
+		return
+	}
+
+	// Link to the next page of the ticker if there are tracks after this one
+	if ticker.TStop < ticker.TLatest {
+		if next, err := req.URLFor("ticker_after", map[string]interface{}{"timestamp": ticker.TStop}); err == nil {
+			req.W.Header().Set("Link", "<"+next+">; rel=\"next\"")
+		}
 	}
 
-	req.SendJSON(ticker, http.StatusOK)
+	req.Respond(ticker, http.StatusOK, router.JSON, router.CSV, router.MSGPACK)
 }
@@ -2,22 +2,31 @@ package track
 
 import (
 	"fmt"
+	"mime"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
 
+	"github.com/haakonleg/imt2681-assig2/geo"
 	"github.com/haakonleg/imt2681-assig2/mdb"
 	"github.com/haakonleg/imt2681-assig2/router"
-	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/haakonleg/imt2681-assig2/scoring"
 
 	igc "github.com/marni/goigc"
-	"github.com/mongodb/mongo-go-driver/bson"
-	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// The error codes used by the track handlers, in addition to the ones in the router package
+const (
+	CodeInvalidDistanceModel = "invalid_distance_model"
+	CodeInvalidIGCURL        = "invalid_igc_url"
+	CodeInvalidIGC           = "invalid_igc"
+	CodeMissingIGCFile       = "missing_igc_file"
 )
 
 type PostTrackRequest struct {
-	URL string `json:"url"`
+	URL           string `json:"url"`
+	DistanceModel string `json:"distance_model,omitempty"`
 }
 
 type PostTrackResponse struct {
@@ -25,58 +34,51 @@ type PostTrackResponse struct {
 }
 
 type TrackHandler struct {
-	db                    *mdb.Database
-	trackRegisterCallback func(*mdb.Database)
+	db           mdb.Storage
+	events       chan<- TrackRegistered
+	scoringRules scoring.Rules
 }
 
 // NewTrackHandler creates a new TrackHandler object
-func NewTrackHandler(db *mdb.Database) *TrackHandler {
+func NewTrackHandler(db mdb.Storage) *TrackHandler {
 	return &TrackHandler{
-		db: db}
-}
-
-// SetTrackRegisterCallback sets a callback function that will be called when a new track is
-// registered in the database
-func (th *TrackHandler) SetTrackRegisterCallback(f func(*mdb.Database)) {
-	th.trackRegisterCallback = f
+		db:           db,
+		scoringRules: scoring.DefaultRules}
 }
 
 // GetAllTracks is the handler for the API path GET /api/track
-// Returns an array of IDs of all tracks stored in the database
+// Returns an array of IDs of the tracks stored in the database. The tracks can be filtered with the query parameters
+// pilot, glider, glider_id, date_from, date_to, ts_from, ts_to, min_length and max_length, and sorted with sort.
+// At most limit IDs are returned (1000 by default, which is also the maximum), if there are more tracks the Link
+// header contains the URL to the next page. Clients must follow the links to get all the tracks
 func (th *TrackHandler) GetAllTracks(req *router.Request) {
-	// Only get the id
-	findopts := []findopt.Find{
-		findopt.Projection(bson.NewDocument(bson.EC.Int64("_id", 1)))}
-
-	// Get all track IDs in database
-	tracks := make([]*mdb.Track, 0)
-	if err := th.db.Find(mdb.TRACKS, nil, findopts, &tracks); err != nil {
-		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Message: "Internal database error"})
+	filter, rErr := th.parseTrackFilter(req)
+	if rErr != nil {
+		req.SendError(rErr)
 		return
 	}
 
-	// Retrieve all the IDs into a slice
-	ids := make([]string, 0, len(tracks))
-	for _, track := range tracks {
-		ids = append(ids, track.ID.Hex())
+	// Get one more ID than the limit, to know if there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	ids, err := th.db.FindTrackIDs(filter)
+	if err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
 	}
 
-	req.SendJSON(&ids, http.StatusOK)
-}
-
-// ValidateTrackID is the validation function used by the router to validate the track ID
-// the ID is a hex encoded string consisting of 24 characters
-func ValidateTrackID(variable string) (bool, interface{}) {
-	validChars := "0123456789abcdef"
-	if len(variable) != 24 {
-		return false, nil
-	}
-	for _, ch := range variable {
-		if !strings.ContainsRune(validChars, ch) {
-			return false, nil
+	if int64(len(ids)) > limit {
+		ids = ids[:limit]
+		last, err := th.db.GetTrack(ids[limit-1])
+		if err != nil {
+			req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+			return
+		}
+		if link, err := nextLink(req, last); err == nil {
+			req.W.Header().Set("Link", link)
 		}
 	}
-	return true, variable
+	req.Respond(trackIDs(ids), http.StatusOK, router.JSON, router.CSV, router.MSGPACK)
 }
 
 // GetTrack is the handler for the API path GET /api/track/{id}
@@ -84,25 +86,21 @@ func ValidateTrackID(variable string) (bool, interface{}) {
 func (th *TrackHandler) GetTrack(req *router.Request) {
 	id := req.Vars["id"].(string)
 
-	// Only get the requested track
-	objectID, err := objectid.FromHex(id)
-	if err != nil {
-		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Message: "Invalid ID"})
+	track, err := th.db.GetTrack(id)
+	if err == mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidID, Message: "Invalid ID"})
 		return
 	}
-	filter := bson.NewDocument(bson.EC.ObjectID("_id", objectID))
-
-	tracks := make([]*mdb.Track, 0)
-	if err := th.db.Find(mdb.TRACKS, filter, nil, &tracks); err != nil {
-		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Message: "Internal database error"})
+	if err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
 		return
 	}
-	if len(tracks) < 1 {
-		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Message: "Invalid ID"})
+
+	offers := []router.ResponseType{router.JSON, router.MSGPACK}
+	if notModified(req, track, offers) {
 		return
 	}
-
-	req.SendJSON(&tracks[0], http.StatusOK)
+	req.Respond(track, http.StatusOK, offers...)
 }
 
 // ValidateTrackField is the validator used by the router to validate a request for the field
@@ -111,7 +109,13 @@ func ValidateTrackField(variable string) (bool, interface{}) {
 	validFields := []string{
 		"pilot", "glider",
 		"glider_id", "track_length",
-		"H_date", "track_src_url"}
+		"distance_model",
+		"H_date", "track_src_url",
+		"takeoff_time", "landing_time", "flight_duration",
+		"max_pressure_alt", "min_pressure_alt",
+		"max_gnss_alt", "min_gnss_alt",
+		"max_climb", "max_sink",
+		"max_speed", "avg_speed"}
 	for _, field := range validFields {
 		if variable == field {
 			return true, variable
@@ -121,72 +125,115 @@ func ValidateTrackField(variable string) (bool, interface{}) {
 }
 
 // GetTrackField is the handler for the API path GET /api/track/{id}/{field}
-// Returns a specified field within the track object from the database in plain text
+// Returns a specified field within the track object from the database in plain text, or as a JSON or MessagePack string
 func (th *TrackHandler) GetTrackField(req *router.Request) {
 	id := req.Vars["id"].(string)
 	field := req.Vars["field"].(string)
 
-	objectID, _ := objectid.FromHex(id)
-
-	// Only get the requested field
-	filter := bson.NewDocument(bson.EC.ObjectID("_id", objectID))
-	findopts := []findopt.Find{
-		findopt.Projection(bson.NewDocument(bson.EC.Int64(field, 1)))}
-
-	tracks := make([]*mdb.Track, 0)
-	if err := th.db.Find(mdb.TRACKS, filter, findopts, &tracks); err != nil {
-		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Message: "Internal database error"})
+	track, err := th.db.GetTrack(id)
+	if err == mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidID, Message: "Invalid ID"})
 		return
 	}
-	if len(tracks) < 1 {
-		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Message: "Invalid ID"})
+	if err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
 		return
 	}
 
-	req.SendText(tracks[0].Field(field), http.StatusOK)
+	offers := []router.ResponseType{router.TEXT, router.JSON, router.MSGPACK}
+	if notModified(req, track, offers) {
+		return
+	}
+	req.Respond(track.Field(field), http.StatusOK, offers...)
 }
 
 // PostTrack is the handler for the API path POST /api/track
-// Register/upload a track using a URL to an IGC track resource
+// Register/upload a track using a URL to an IGC track resource (JSON), or by uploading the IGC
+// file directly, either as multipart/form-data or as the raw request body (application/octet-stream)
+// The distance model used for the track length is chosen with the JSON field or query parameter "distance_model"
 func (th *TrackHandler) PostTrack(req *router.Request) {
-	request := new(PostTrackRequest)
-
-	// Get the JSON post request
-	if err := req.ParseJSONRequest(request); err != nil {
-		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Message: "Invalid JSON"})
-		return
+	var track *igc.Track
+	request := &PostTrackRequest{DistanceModel: req.R.URL.Query().Get("distance_model")}
+	var rErr *router.Error
+
+	// The request is validated before the IGC file is retrieved or read
+	mediaType, _, _ := mime.ParseMediaType(req.R.Header.Get("Content-Type"))
+	upload := mediaType == "multipart/form-data" || mediaType == "application/octet-stream"
+	if !upload {
+		if rErr = parseTrackRequest(req, request); rErr != nil {
+			req.SendError(rErr)
+			return
+		}
 	}
-
-	// Check that the supplied link is valid
-	if valid := ensureIGCLink(request.URL); !valid {
-		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Message: "This is not a valid IGC resource"})
+	model, ok := geo.ParseModel(request.DistanceModel)
+	if !ok {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidDistanceModel, Message: "Invalid distance model"})
 		return
 	}
 
-	// Parse the IGC file
-	igc, err := igc.ParseLocation(request.URL)
-	if err != nil {
-		fmt.Println(err)
-		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Message: "Error parsing IGC file"})
+	switch mediaType {
+	case "multipart/form-data":
+		track, rErr = parseIGCMultipart(req)
+	case "application/octet-stream":
+		track, rErr = parseIGCBody(req)
+	default:
+		track, rErr = parseIGCURL(request.URL)
+	}
+	if rErr != nil {
+		req.SendError(rErr)
 		return
 	}
 
 	// Send response containing the ID to the inserted track
-	newTrack := mdb.CreateTrack(&igc, request.URL)
-	id, err := th.db.InsertObject(mdb.TRACKS, &newTrack)
+	points := mdb.CreatePoints(track)
+	newTrack := mdb.CreateTrack(track, points, request.URL, model)
+	id, err := th.db.InsertTrack(&newTrack, points)
 	if err != nil {
-		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Message: "Internal database error"})
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
 		return
 	}
 
-	// Send response
+	// Send response, with the URL to the new track in the Location header
+	if location, err := req.URLFor("track", map[string]interface{}{"id": id}); err == nil {
+		req.W.Header().Set("Location", location)
+	}
 	response := &PostTrackResponse{id}
 	req.SendJSON(response, http.StatusOK)
 
-	// Call the callback
-	if th.trackRegisterCallback != nil {
-		th.trackRegisterCallback(th.db)
+	// Notify the listener that a track was registered, without waiting for it
+	if th.events != nil {
+		select {
+		case th.events <- TrackRegistered{ID: id, Track: &newTrack}:
+		default:
+		}
+	}
+}
+
+// parseTrackRequest parses a JSON request containing a URL to an IGC resource into request
+func parseTrackRequest(req *router.Request, request *PostTrackRequest) *router.Error {
+	// Get the JSON post request
+	if err := req.ParseJSONRequest(request); err != nil {
+		if jErr, ok := err.(*router.JSONError); ok {
+			return jErr.RouterError()
+		}
+		return &router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidJSON, Message: "Invalid JSON"}
+	}
+
+	// Check that the supplied link is valid
+	if valid := ensureIGCLink(request.URL); !valid {
+		return &router.Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidIGCURL, Message: "This is not a valid IGC resource"}
+	}
+	return nil
+}
+
+// parseIGCURL retrieves and parses the IGC file at the URL
+func parseIGCURL(igcURL string) (*igc.Track, *router.Error) {
+	track, err := igc.ParseLocation(igcURL)
+	if err != nil {
+		fmt.Println(err)
+		return nil, &router.Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidIGC, Message: "Error parsing IGC file"}
 	}
+	return &track, nil
 }
 
 // Ensures that a link points to an IGC resource (but just that it is a valid URL and has an igc extension)
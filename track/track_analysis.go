@@ -0,0 +1,37 @@
+package track
+
+import (
+	"net/http"
+
+	"github.com/haakonleg/imt2681-assig2/analysis"
+	"github.com/haakonleg/imt2681-assig2/mdb"
+	"github.com/haakonleg/imt2681-assig2/router"
+)
+
+// GetTrackAnalysis is the handler for the API path GET /api/track/{id}/analysis
+// Splits the flight into thermals and glides, and returns statistics about each of them
+func (th *TrackHandler) GetTrackAnalysis(req *router.Request) {
+	id := req.Vars["id"].(string)
+
+	track, err := th.db.GetTrack(id)
+	if err == mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidID, Message: "Invalid ID"})
+		return
+	}
+	if err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+	offers := []router.ResponseType{router.JSON, router.MSGPACK}
+	if notModified(req, track, offers) {
+		return
+	}
+
+	points, err := th.db.GetTrackPoints(id)
+	if err != nil && err != mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+
+	req.Respond(analysis.Analyse(points, track.TakeoffTime, track.LandingTime), http.StatusOK, offers...)
+}
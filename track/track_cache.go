@@ -0,0 +1,38 @@
+package track
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/mdb"
+	"github.com/haakonleg/imt2681-assig2/router"
+)
+
+// notModified sets the caching headers of a response about a track, and sends 304 if the client already has it
+// The entity tag is derived from the track and the request, since the response depends on the path, the query
+// parameters and the response type negotiated from offers (nil if the type does not depend on the Accept header).
+// extra contains anything else the response depends on
+// Caches must revalidate the responses every time, since tracks can be deleted
+func notModified(req *router.Request, track *mdb.Track, offers []router.ResponseType, extra ...string) bool {
+	contentType := ""
+	if len(offers) > 0 {
+		responseType, ok := req.Negotiate(offers...)
+		if !ok {
+			// The handler sends 406
+			return false
+		}
+		contentType = responseType.ContentType()
+		router.AddVary(req.W.Header(), "Accept")
+	}
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%d\n%s\n%s\n%s\n%s", track.ID.Hex(), track.Ts, req.R.URL.Path, req.R.URL.RawQuery,
+		contentType, strings.Join(extra, "\n"))
+	etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+
+	req.W.Header().Set("Cache-Control", "no-cache")
+	return req.NotModified(etag, time.Unix(0, track.Ts*int64(time.Millisecond)))
+}
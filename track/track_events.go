@@ -0,0 +1,17 @@
+package track
+
+import "github.com/haakonleg/imt2681-assig2/mdb"
+
+// TrackRegistered is the event that is sent when a new track has been registered
+type TrackRegistered struct {
+	ID    string
+	Track *mdb.Track
+}
+
+// SetTrackEvents sets the channel that TrackRegistered events are sent on. The events are sent without blocking,
+// and dropped if the channel is full. New tracks are stored with WebhooksPending set, so the receiver should take
+// an event as a signal to claim the pending tracks (see mdb.Storage.ClaimPendingTrack), not as the only record of
+// a track
+func (th *TrackHandler) SetTrackEvents(events chan<- TrackRegistered) {
+	th.events = events
+}
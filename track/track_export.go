@@ -0,0 +1,218 @@
+package track
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/mdb"
+	"github.com/haakonleg/imt2681-assig2/router"
+)
+
+// exporter renders a track and its points in an export format
+type exporter struct {
+	contentType string
+	extension   string
+	render      func(track *mdb.Track, points []mdb.Point) ([]byte, error)
+}
+
+// The supported export formats, selected with the "format" query parameter
+var exporters = map[string]exporter{
+	"geojson": {"application/geo+json", "geojson", renderGeoJSON},
+	"kml":     {"application/vnd.google-earth.kml+xml", "kml", renderKML},
+	"gpx":     {"application/gpx+xml", "gpx", renderGPX}}
+
+// exportQuery contains the query parameters of GET /api/track/{id}/export
+type exportQuery struct {
+	Format string `query:"format" default:"geojson" enum:"geojson,kml,gpx"`
+}
+
+// GetTrackExport is the handler for the API path GET /api/track/{id}/export
+// Renders the track as a GeoJSON Feature, or as a KML or GPX document, selected by the query parameter
+// "format" (geojson, kml or gpx). The default format is geojson
+func (th *TrackHandler) GetTrackExport(req *router.Request) {
+	id := req.Vars["id"].(string)
+
+	query := new(exportQuery)
+	if rErr := req.BindQuery(query); rErr != nil {
+		req.SendError(rErr)
+		return
+	}
+	exp := exporters[query.Format]
+
+	track, err := th.db.GetTrack(id)
+	if err == mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidID, Message: "Invalid ID"})
+		return
+	}
+	if err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+	if notModified(req, track, nil) {
+		return
+	}
+
+	points, err := th.db.GetTrackPoints(id)
+	if err != nil && err != mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+
+	data, err := exp.render(track, points)
+	if err != nil {
+		fmt.Println(err)
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeInternalError, Message: "Error rendering track"})
+		return
+	}
+
+	req.W.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", id, exp.extension))
+	req.SendData(data, exp.contentType, http.StatusOK)
+}
+
+// Formats a coordinate in decimal degrees
+func formatCoord(deg float64) string {
+	return strconv.FormatFloat(deg, 'f', 6, 64)
+}
+
+// GeoJSON structures (RFC 7946)
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONLineString `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONLineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][3]float64 `json:"coordinates"`
+}
+
+type geoJSONProperties struct {
+	Pilot      string   `json:"pilot"`
+	Glider     string   `json:"glider"`
+	GliderID   string   `json:"glider_id"`
+	Date       string   `json:"date"`
+	CoordTimes []string `json:"coordTimes"`
+}
+
+// Renders the track as a GeoJSON Feature with a LineString geometry, the timestamps of the
+// fixes are in the coordTimes property
+func renderGeoJSON(track *mdb.Track, points []mdb.Point) ([]byte, error) {
+	alt := mdb.VarioAltitude(points)
+
+	feature := geoJSONFeature{
+		Type: "Feature",
+		Geometry: geoJSONLineString{
+			Type:        "LineString",
+			Coordinates: make([][3]float64, 0, len(points))},
+		Properties: geoJSONProperties{
+			Pilot:      track.Pilot,
+			Glider:     track.Glider,
+			GliderID:   track.GliderID,
+			Date:       track.HDate,
+			CoordTimes: make([]string, 0, len(points))}}
+
+	for _, p := range points {
+		feature.Geometry.Coordinates = append(feature.Geometry.Coordinates, [3]float64{p.Lng, p.Lat, float64(alt(p))})
+		feature.Properties.CoordTimes = append(feature.Properties.CoordTimes, p.Time.Format(time.RFC3339))
+	}
+
+	return json.Marshal(&feature)
+}
+
+// KML structures, the fixes are rendered as a gx:Track which has a timestamp per fix
+type kmlDocument struct {
+	XMLName   xml.Name     `xml:"kml"`
+	XMLNS     string       `xml:"xmlns,attr"`
+	XMLNSGX   string       `xml:"xmlns:gx,attr"`
+	Name      string       `xml:"Document>name"`
+	Placemark kmlPlacemark `xml:"Document>Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name        string   `xml:"name"`
+	Description string   `xml:"description"`
+	AltMode     string   `xml:"gx:Track>altitudeMode"`
+	When        []string `xml:"gx:Track>when"`
+	Coords      []string `xml:"gx:Track>gx:coord"`
+}
+
+// Renders the track as a KML document
+func renderKML(track *mdb.Track, points []mdb.Point) ([]byte, error) {
+	alt := mdb.VarioAltitude(points)
+
+	doc := kmlDocument{
+		XMLNS:   "http://www.opengis.net/kml/2.2",
+		XMLNSGX: "http://www.google.com/kml/ext/2.2",
+		Name:    track.Pilot + " " + track.HDate,
+		Placemark: kmlPlacemark{
+			Name:        track.Pilot,
+			Description: fmt.Sprintf("Glider: %s (%s), date: %s", track.Glider, track.GliderID, track.HDate),
+			AltMode:     "absolute",
+			When:        make([]string, 0, len(points)),
+			Coords:      make([]string, 0, len(points))}}
+
+	for _, p := range points {
+		doc.Placemark.When = append(doc.Placemark.When, p.Time.Format(time.RFC3339))
+		doc.Placemark.Coords = append(doc.Placemark.Coords, formatCoord(p.Lng)+" "+formatCoord(p.Lat)+" "+strconv.FormatInt(alt(p), 10))
+	}
+
+	return marshalXML(&doc)
+}
+
+// GPX 1.1 structures
+type gpxDocument struct {
+	XMLName  xml.Name `xml:"gpx"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	Version  string   `xml:"version,attr"`
+	Creator  string   `xml:"creator,attr"`
+	Name     string   `xml:"metadata>name"`
+	Author   string   `xml:"metadata>author>name"`
+	TrkName  string   `xml:"trk>name"`
+	TrkDesc  string   `xml:"trk>desc"`
+	TrkPoint []gpxPt  `xml:"trk>trkseg>trkpt"`
+}
+
+type gpxPt struct {
+	Lat  string `xml:"lat,attr"`
+	Lon  string `xml:"lon,attr"`
+	Ele  int64  `xml:"ele"`
+	Time string `xml:"time"`
+}
+
+// Renders the track as a GPX document
+func renderGPX(track *mdb.Track, points []mdb.Point) ([]byte, error) {
+	alt := mdb.VarioAltitude(points)
+
+	doc := gpxDocument{
+		XMLNS:    "http://www.topografix.com/GPX/1/1",
+		Version:  "1.1",
+		Creator:  "paragliding",
+		Name:     track.Pilot + " " + track.HDate,
+		Author:   track.Pilot,
+		TrkName:  track.Glider + " " + track.GliderID,
+		TrkDesc:  "Date: " + track.HDate,
+		TrkPoint: make([]gpxPt, 0, len(points))}
+
+	for _, p := range points {
+		doc.TrkPoint = append(doc.TrkPoint, gpxPt{
+			Lat:  formatCoord(p.Lat),
+			Lon:  formatCoord(p.Lng),
+			Ele:  alt(p),
+			Time: p.Time.Format(time.RFC3339)})
+	}
+
+	return marshalXML(&doc)
+}
+
+// Marshals an XML document and adds the XML header
+func marshalXML(doc interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
@@ -0,0 +1,87 @@
+package track
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/mdb"
+	"github.com/haakonleg/imt2681-assig2/router"
+)
+
+// pointsQuery contains the query parameters of GET /api/track/{id}/points
+type pointsQuery struct {
+	From time.Time `query:"from"`
+	To   time.Time `query:"to"`
+	Step int       `query:"step" default:"1" min:"1"`
+}
+
+// GetTrackPoints is the handler for the API path GET /api/track/{id}/points
+// Returns the GPS fixes of a track. The optional query parameters "from" and "to" (RFC 3339 timestamps)
+// limit the points to a time window, and "step" returns only every n-th point (decimation)
+func (th *TrackHandler) GetTrackPoints(req *router.Request) {
+	id := req.Vars["id"].(string)
+
+	query := new(pointsQuery)
+	if rErr := req.BindQuery(query); rErr != nil {
+		req.SendError(rErr)
+		return
+	}
+
+	// Make sure the track exists
+	track, err := th.db.GetTrack(id)
+	if err == mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidID, Message: "Invalid ID"})
+		return
+	} else if err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+	offers := []router.ResponseType{router.JSON, router.CSV, router.MSGPACK}
+	if notModified(req, track, offers) {
+		return
+	}
+
+	points, err := th.db.GetTrackPoints(id)
+	if err != nil && err != mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+
+	req.Respond(pointList(filterPoints(points, query.From, query.To, query.Step)), http.StatusOK, offers...)
+}
+
+// pointList is a list of points, which can be sent as CSV with one point per row
+type pointList []mdb.Point
+
+// MarshalCSV returns the points as CSV records
+func (points pointList) MarshalCSV() [][]string {
+	records := make([][]string, 0, len(points)+1)
+	records = append(records, []string{"time", "lat", "lng", "pressure_alt", "gnss_alt", "valid"})
+	for _, p := range points {
+		records = append(records, []string{
+			p.Time.Format(time.RFC3339),
+			strconv.FormatFloat(p.Lat, 'f', -1, 64),
+			strconv.FormatFloat(p.Lng, 'f', -1, 64),
+			strconv.FormatInt(p.PressureAlt, 10),
+			strconv.FormatInt(p.GNSSAlt, 10),
+			strconv.FormatBool(p.Valid)})
+	}
+	return records
+}
+
+// Returns the points within the time window from-to (if they are not zero), and only every step-th point
+func filterPoints(points []mdb.Point, from, to time.Time, step int) []mdb.Point {
+	filtered := make([]mdb.Point, 0, len(points)/step+1)
+	n := 0
+	for _, p := range points {
+		if (!from.IsZero() && p.Time.Before(from)) || (!to.IsZero() && p.Time.After(to)) {
+			continue
+		}
+		if n%step == 0 {
+			filtered = append(filtered, p)
+		}
+		n++
+	}
+	return filtered
+}
@@ -0,0 +1,43 @@
+package track
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/haakonleg/imt2681-assig2/mdb"
+	"github.com/haakonleg/imt2681-assig2/router"
+	"github.com/haakonleg/imt2681-assig2/scoring"
+)
+
+// SetScoringRules sets the multipliers and closing ratio used when scoring tracks
+func (th *TrackHandler) SetScoringRules(rules scoring.Rules) {
+	th.scoringRules = rules
+}
+
+// GetTrackScore is the handler for the API path GET /api/track/{id}/score
+// Scores the flight as free distance, flat triangle and FAI triangle, and returns the points and turnpoints
+func (th *TrackHandler) GetTrackScore(req *router.Request) {
+	id := req.Vars["id"].(string)
+
+	// Make sure the track exists
+	track, err := th.db.GetTrack(id)
+	if err == mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidID, Message: "Invalid ID"})
+		return
+	} else if err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+	offers := []router.ResponseType{router.JSON, router.MSGPACK}
+	if notModified(req, track, offers, fmt.Sprint(th.scoringRules)) {
+		return
+	}
+
+	points, err := th.db.GetTrackPoints(id)
+	if err != nil && err != mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+
+	req.Respond(scoring.ScoreFlight(points, th.scoringRules), http.StatusOK, offers...)
+}
@@ -0,0 +1,142 @@
+package track
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/mdb"
+	"github.com/haakonleg/imt2681-assig2/router"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+const (
+	// MaxTrackLimit is the maximum (and default) amount of track IDs returned in one page by GET /api/track
+	MaxTrackLimit = 1000
+)
+
+// trackIDs is a list of track IDs, which can be sent as CSV with one ID per row
+type trackIDs []string
+
+// MarshalCSV returns the IDs as CSV records
+func (ids trackIDs) MarshalCSV() [][]string {
+	records := make([][]string, 0, len(ids)+1)
+	records = append(records, []string{"id"})
+	for _, id := range ids {
+		records = append(records, []string{id})
+	}
+	return records
+}
+
+// trackQuery contains the query parameters of GET /api/track
+// Sort is a sortable field, prefixed with "-" to sort in descending order. Cursor is the position after the last
+// track in the previous page, see trackCursor
+type trackQuery struct {
+	Pilot     string    `query:"pilot"`
+	Glider    string    `query:"glider"`
+	GliderID  string    `query:"glider_id"`
+	DateFrom  time.Time `query:"date_from" layout:"2006-01-02"`
+	DateTo    time.Time `query:"date_to" layout:"2006-01-02"`
+	TsFrom    int64     `query:"ts_from" min:"0"`
+	TsTo      int64     `query:"ts_to" min:"0"`
+	MinLength float64   `query:"min_length" min:"0"`
+	MaxLength float64   `query:"max_length" min:"0"`
+	Sort      string    `query:"sort" default:"ts" enum:"ts,-ts,H_date,-H_date,track_length,-track_length"`
+	Limit     int64     `query:"limit" default:"1000" min:"1" max:"1000"`
+	Cursor    string    `query:"cursor"`
+}
+
+// parseTrackFilter creates the track filter from the query parameters of a GET /api/track request
+func (th *TrackHandler) parseTrackFilter(req *router.Request) (*mdb.TrackFilter, *router.Error) {
+	query := new(trackQuery)
+	if rErr := req.BindQuery(query); rErr != nil {
+		return nil, rErr
+	}
+
+	filter := &mdb.TrackFilter{
+		Pilot:      query.Pilot,
+		Glider:     query.Glider,
+		GliderID:   query.GliderID,
+		DateFrom:   query.DateFrom,
+		DateTo:     query.DateTo,
+		TsFrom:     query.TsFrom,
+		TsTo:       query.TsTo,
+		MinLength:  query.MinLength,
+		MaxLength:  query.MaxLength,
+		SortField:  strings.TrimPrefix(query.Sort, "-"),
+		Descending: strings.HasPrefix(query.Sort, "-"),
+		Limit:      query.Limit}
+
+	if query.Cursor != "" {
+		after, ok := decodeCursor(query.Cursor, query.Sort)
+		if !ok {
+			return nil, router.InvalidParams(router.ParamError{Name: "cursor", Reason: "must be the cursor of the next page with the same sort order"})
+		}
+		filter.After = after
+	}
+	return filter, nil
+}
+
+// trackCursor is the position after a track in the sort order of GET /api/track. It contains the value of the
+// sort field and the ID of the track, so the next page can be found even if the track has been deleted since
+type trackCursor struct {
+	Sort   string  `json:"s"`
+	ID     string  `json:"id"`
+	Ts     int64   `json:"ts,omitempty"`
+	Date   string  `json:"date,omitempty"`
+	Length float64 `json:"len,omitempty"`
+}
+
+// encodeCursor returns the cursor of the position after the track, for the sort order (the query parameter sort)
+func encodeCursor(track *mdb.Track, sort string) string {
+	cursor := trackCursor{Sort: sort, ID: track.ID.Hex()}
+	switch strings.TrimPrefix(sort, "-") {
+	case mdb.SortTimestamp:
+		cursor.Ts = track.Ts
+	case mdb.SortDate:
+		cursor.Date = track.HDate
+	case mdb.SortLength:
+		cursor.Length = track.TrackLength
+	}
+
+	data, _ := json.Marshal(&cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes a cursor created by encodeCursor into a track with the ID and sort value of the cursor
+// Returns false if the cursor is invalid, or if it was created for another sort order
+func decodeCursor(value string, sort string) (*mdb.Track, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, false
+	}
+	cursor := new(trackCursor)
+	if err := json.Unmarshal(data, cursor); err != nil || cursor.Sort != sort {
+		return nil, false
+	}
+	id, err := objectid.FromHex(cursor.ID)
+	if err != nil {
+		return nil, false
+	}
+	return &mdb.Track{ID: id, Ts: cursor.Ts, HDate: cursor.Date, TrackLength: cursor.Length}, true
+}
+
+// nextLink returns the value of the Link header pointing to the next page, which is the same request with the cursor
+// set to the position after the last track in the current page
+func nextLink(req *router.Request, last *mdb.Track) (string, error) {
+	path, err := req.URLFor("tracks", nil)
+	if err != nil {
+		return "", err
+	}
+
+	query := req.R.URL.Query()
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = mdb.SortTimestamp
+	}
+	query.Set("cursor", encodeCursor(last, sort))
+	next := url.URL{Path: path, RawQuery: query.Encode()}
+	return "<" + next.String() + ">; rel=\"next\"", nil
+}
@@ -0,0 +1,110 @@
+package track
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/haakonleg/imt2681-assig2/router"
+
+	igc "github.com/marni/goigc"
+)
+
+const (
+	// MaxIGCSize is the maximum size in bytes of an uploaded IGC file
+	MaxIGCSize = 10 << 20
+	// IGCFormField is the name of the form field containing the IGC file in multipart uploads
+	IGCFormField = "file"
+)
+
+// parseIGCMultipart parses an IGC file uploaded as multipart/form-data, in the form field IGCFormField
+func parseIGCMultipart(req *router.Request) (*igc.Track, *router.Error) {
+	req.R.Body = http.MaxBytesReader(req.W, req.R.Body, MaxIGCSize)
+	if err := req.R.ParseMultipartForm(MaxIGCSize); err != nil {
+		fmt.Println(err)
+		return nil, uploadError(err)
+	}
+	defer req.R.MultipartForm.RemoveAll()
+
+	file, _, err := req.R.FormFile(IGCFormField)
+	if err != nil {
+		return nil, &router.Error{StatusCode: http.StatusBadRequest, Code: CodeMissingIGCFile, Message: "Missing form field \"" + IGCFormField + "\""}
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		fmt.Println(err)
+		return nil, uploadError(err)
+	}
+	return parseIGCContent(content)
+}
+
+// parseIGCBody parses an IGC file uploaded as the raw request body (application/octet-stream)
+func parseIGCBody(req *router.Request) (*igc.Track, *router.Error) {
+	body := http.MaxBytesReader(req.W, req.R.Body, MaxIGCSize)
+	defer body.Close()
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		fmt.Println(err)
+		return nil, uploadError(err)
+	}
+	return parseIGCContent(content)
+}
+
+// parseIGCContent validates the content of an uploaded IGC file and parses it
+func parseIGCContent(content []byte) (*igc.Track, *router.Error) {
+	if !isIGCContent(content) {
+		return nil, &router.Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidIGC, Message: "This is not a valid IGC file"}
+	}
+
+	track, err := igc.Parse(string(content))
+	if err != nil {
+		fmt.Println(err)
+		return nil, &router.Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidIGC, Message: "Error parsing IGC file"}
+	}
+	if len(track.Points) < 1 {
+		return nil, &router.Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidIGC, Message: "The IGC file contains no fixes"}
+	}
+	return &track, nil
+}
+
+// isIGCContent checks that the content looks like an IGC file: it is plain text, the first
+// record is the manufacturer (A) record, and it contains at least one fix (B) record
+func isIGCContent(content []byte) bool {
+	if len(content) == 0 || bytes.IndexByte(content, 0) != -1 {
+		return false
+	}
+
+	first := true
+	hasFix := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if first && line[0] != 'A' {
+			return false
+		}
+		if line[0] == 'B' {
+			hasFix = true
+		}
+		first = false
+	}
+	return scanner.Err() == nil && hasFix
+}
+
+// Returns the router error for an error that occured while reading an upload
+func uploadError(err error) *router.Error {
+	var sizeErr *http.MaxBytesError
+	if errors.As(err, &sizeErr) {
+		return &router.Error{StatusCode: http.StatusRequestEntityTooLarge, Code: router.CodeBodyTooLarge, Message: fmt.Sprintf("The IGC file is larger than %d bytes", MaxIGCSize)}
+	}
+	return &router.Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidIGC, Message: "Error reading IGC file"}
+}
@@ -0,0 +1,188 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/mdb"
+	"github.com/haakonleg/imt2681-assig2/router"
+	"github.com/haakonleg/imt2681-assig2/ticker"
+	"github.com/haakonleg/imt2681-assig2/util"
+)
+
+// DeliveryPolicy configures how webhook deliveries are sent and retried
+// A delivery is attempted at most MaxAttempts times before it is moved to the dead-letter state. The delay before
+// attempt n+1 is BaseDelay * 2^(n-1), at most MaxDelay, with random jitter of up to half the delay. The stored
+// deliveries are checked for retries that are due every PollInterval, and each due delivery is claimed with a lease
+// of twice Timeout, so that only one server sends it when several servers share the storage
+// The deliveries are sent by Workers goroutines, with at most MaxPerHost concurrent requests to the same host,
+// and each request times out after Timeout. At most QueueSize deliveries wait for a worker, the rest are left
+// for a later poll
+type DeliveryPolicy struct {
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	PollInterval time.Duration
+
+	Workers    int
+	MaxPerHost int
+	Timeout    time.Duration
+	QueueSize  int
+}
+
+// DefaultDeliveryPolicy retries a delivery for about four hours
+var DefaultDeliveryPolicy = DeliveryPolicy{
+	MaxAttempts:  8,
+	BaseDelay:    30 * time.Second,
+	MaxDelay:     time.Hour,
+	PollInterval: 10 * time.Second,
+	Workers:      8,
+	MaxPerHost:   2,
+	Timeout:      10 * time.Second,
+	QueueSize:    1000}
+
+// deliveriesQuery contains the query parameters of GET /api/webhook/new_track/{id}/deliveries
+type deliveriesQuery struct {
+	Limit int64 `query:"limit" default:"100" min:"1" max:"1000"`
+}
+
+// SetDeliveryPolicy sets how webhook deliveries are sent and retried, it must be called before Start
+func (wh *WebhookHandler) SetDeliveryPolicy(policy DeliveryPolicy) {
+	wh.policy = policy
+}
+
+// enqueue stores a delivery to the webhook containing information about the tracks matching its filter that were
+// added since it was last invoked
+// The delivery is stored as due, it is claimed by the worker that the caller dispatches it to, or by the next poll
+// if the queue is full
+func (wh *WebhookHandler) enqueue(webhook *mdb.Webhook) (*mdb.Delivery, error) {
+	var payload []byte
+	ticker, er := ticker.MakeFilteredTicker(wh.db, 0, webhook.LastInvoked, webhook.Filter.Matches)
+	if er != nil {
+		payload, _ = json.Marshal(er)
+	} else {
+		payload, _ = json.Marshal(ticker)
+	}
+
+	deliveryID, err := newDeliveryID()
+	if err != nil {
+		return nil, err
+	}
+	delivery := mdb.CreateDelivery(webhook, deliveryID, payload, util.NowMilli())
+	if _, err := wh.db.InsertDelivery(&delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// attempt sends a delivery to the webhook and records the attempt. If it fails, the next attempt is scheduled with
+// exponential backoff, or the delivery is moved to the dead-letter state if it has been attempted too many times
+// webhook is nil if it has been deleted
+func (wh *WebhookHandler) attempt(delivery *mdb.Delivery, webhook *mdb.Webhook) {
+	var attempt mdb.DeliveryAttempt
+	if webhook != nil {
+		attempt = wh.send(webhook, delivery)
+	} else {
+		attempt = mdb.DeliveryAttempt{Time: util.NowMilli(), Error: "the webhook has been deleted"}
+	}
+
+	attempts := len(delivery.Attempts) + 1
+	switch {
+	case attempt.Error == "":
+		delivery.Status = mdb.DeliveryDelivered
+		delivery.NextAttempt = 0
+	case webhook == nil || attempts >= wh.policy.MaxAttempts:
+		delivery.Status = mdb.DeliveryDead
+		delivery.NextAttempt = 0
+		fmt.Printf("Delivery %s to webhook %s failed %d times: %s\n", delivery.DeliveryID, delivery.WebhookID.Hex(), attempts, attempt.Error)
+	default:
+		delivery.Status = mdb.DeliveryPending
+		delivery.NextAttempt = attempt.Time + wh.backoff(attempts).Nanoseconds()/int64(time.Millisecond)
+	}
+
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	if err := wh.db.AddDeliveryAttempt(delivery, attempt); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// backoff returns the delay before the next attempt of a delivery that has failed the amount of attempts
+func (wh *WebhookHandler) backoff(attempts int) time.Duration {
+	delay := wh.policy.MaxDelay
+	if attempts < 32 {
+		if d := wh.policy.BaseDelay << uint(attempts-1); d > 0 && d < delay {
+			delay = d
+		}
+	}
+
+	// Add jitter, so that deliveries that failed at the same time are not retried at the same time
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// send sends a POST request to the webhook with the payload of the delivery, and returns the result
+// The request is signed with the secret of the webhook, see SignatureHeader
+func (wh *WebhookHandler) send(webhook *mdb.Webhook, delivery *mdb.Delivery) (attempt mdb.DeliveryAttempt) {
+	start := time.Now()
+	attempt.Time = util.NowMilli()
+	defer func() {
+		attempt.Duration = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	}()
+
+	payload := []byte(delivery.Payload)
+	httpReq, err := http.NewRequest("POST", webhook.WebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(DeliveryHeader, delivery.DeliveryID)
+	if webhook.Secret != "" {
+		httpReq.Header.Set(SignatureHeader, signatureHeader(webhook, start, delivery.DeliveryID, payload))
+	}
+
+	resp, err := wh.client.Do(httpReq)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = "the webhook responded with " + resp.Status
+	}
+	fmt.Printf("Invoked webhook %s, delivery %s: %d\n", webhook.WebhookURL, delivery.DeliveryID, resp.StatusCode)
+	return attempt
+}
+
+// GetDeliveries is the handler for the API path GET /api/webhook/new_track/{webhook_id}/deliveries
+// Returns the deliveries to the webhook with each attempt, newest first. The amount is limited with the query
+// parameter "limit" (default 100)
+func (wh *WebhookHandler) GetDeliveries(req *router.Request) {
+	webhookID := req.Vars["id"].(string)
+	query := new(deliveriesQuery)
+	if rErr := req.BindQuery(query); rErr != nil {
+		req.SendError(rErr)
+		return
+	}
+
+	if _, err := wh.db.GetWebhook(webhookID); err == mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidID, Message: "Invalid ID"})
+		return
+	} else if err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+
+	deliveries, err := wh.db.GetDeliveries(webhookID, query.Limit)
+	if err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+	req.Respond(deliveries, http.StatusOK, router.JSON, router.MSGPACK)
+}
@@ -0,0 +1,233 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/mdb"
+	"github.com/haakonleg/imt2681-assig2/track"
+	"github.com/haakonleg/imt2681-assig2/util"
+)
+
+// eventBufferSize is how many TrackRegistered events can wait to be handled. An event only makes the dispatcher
+// claim the pending tracks from storage, so the events that do not fit are dropped without losing any tracks
+const eventBufferSize = 1
+
+// Start starts the dispatcher of webhook deliveries in the background. It counts the pending tracks toward the
+// webhook triggers when an event is sent on Events and every PollInterval, sends the deliveries with a pool of
+// workers, and retries the pending deliveries that are due
+func (wh *WebhookHandler) Start() {
+	wh.client = &http.Client{Timeout: wh.policy.Timeout}
+	wh.events = make(chan track.TrackRegistered, eventBufferSize)
+	wh.jobs = make(chan *mdb.Delivery, wh.policy.QueueSize)
+	wh.stop = make(chan struct{})
+	wh.done = make(chan struct{})
+	wh.inflight = make(map[string]bool)
+	wh.hosts = make(map[string]int)
+
+	wh.producers.Add(2)
+	go wh.listen()
+	go wh.poll()
+
+	wh.workers.Add(wh.policy.Workers)
+	for i := 0; i < wh.policy.Workers; i++ {
+		go wh.work()
+	}
+}
+
+// Events returns the channel that TrackRegistered events are sent to the dispatcher on, it can be used after Start
+func (wh *WebhookHandler) Events() chan<- track.TrackRegistered {
+	return wh.events
+}
+
+// Shutdown stops the dispatcher. The pending tracks are counted, and the deliveries that are waiting for a worker
+// are sent, before it returns. If ctx is done first, its error is returned and the remaining deliveries are left
+// in storage, to be retried when the dispatcher is started again
+// Shutdown can be called more than once. The events sent after it is called are dropped, and their tracks are
+// counted when the dispatcher is started again
+func (wh *WebhookHandler) Shutdown(ctx context.Context) error {
+	wh.shutdown.Do(func() {
+		close(wh.stop)
+		go func() {
+			wh.producers.Wait()
+			close(wh.jobs)
+			wh.workers.Wait()
+			close(wh.done)
+		}()
+	})
+
+	select {
+	case <-wh.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// listen counts the pending tracks each time an event is sent, and one last time when the dispatcher is stopped
+func (wh *WebhookHandler) listen() {
+	defer wh.producers.Done()
+	for {
+		select {
+		case <-wh.stop:
+			wh.countPendingTracks()
+			return
+		case <-wh.events:
+			wh.countPendingTracks()
+		}
+	}
+}
+
+// poll counts the pending tracks and dispatches the pending deliveries that are due every PollInterval, which
+// picks up the tracks of dropped events and of other servers that stopped before they counted them
+func (wh *WebhookHandler) poll() {
+	defer wh.producers.Done()
+	ticker := time.NewTicker(wh.policy.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wh.stop:
+			return
+		case <-ticker.C:
+			wh.countPendingTracks()
+			wh.retryDue()
+		}
+	}
+}
+
+// countPendingTracks claims the tracks that have not been counted toward the webhook triggers, and checks which
+// webhooks should be invoked for each of them
+func (wh *WebhookHandler) countPendingTracks() {
+	for {
+		track, err := wh.db.ClaimPendingTrack()
+		if err == mdb.ErrNotFound {
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		wh.checkInvokeWebhooks(track)
+	}
+}
+
+// retryDue dispatches the pending deliveries where the next attempt is due, as many as there is room for in the
+// queue. They are claimed by the workers, so the deliveries that are left wait in storage for the next poll
+func (wh *WebhookHandler) retryDue() {
+	room := cap(wh.jobs) - len(wh.jobs)
+	if room < 1 {
+		return
+	}
+	deliveries, err := wh.db.GetDueDeliveries(util.NowMilli(), int64(room))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, delivery := range deliveries {
+		wh.dispatch(delivery)
+	}
+}
+
+// leaseUntil returns the end of the lease on a delivery that is claimed at the timestamp now. The lease is twice
+// the request timeout, so that the attempt is recorded before another server can claim the delivery
+func (wh *WebhookHandler) leaseUntil(now int64) int64 {
+	return now + 2*wh.policy.Timeout.Nanoseconds()/int64(time.Millisecond)
+}
+
+// dispatch queues a due delivery to be claimed and sent by a worker, unless it is already queued or being sent
+// If the queue is full, the delivery is left in storage to be dispatched by a later poll
+func (wh *WebhookHandler) dispatch(delivery *mdb.Delivery) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if wh.inflight[delivery.DeliveryID] {
+		return
+	}
+
+	select {
+	case wh.jobs <- delivery:
+		wh.inflight[delivery.DeliveryID] = true
+	default:
+		fmt.Printf("Delivery %s is left for a later poll, the queue is full\n", delivery.DeliveryID)
+	}
+}
+
+// release gives up the lease on a delivery that was claimed but not sent, so that it is dispatched again by the
+// next poll instead of when the lease is over
+func (wh *WebhookHandler) release(delivery *mdb.Delivery) {
+	if err := wh.db.ReleaseDelivery(delivery, util.NowMilli()); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// work sends the queued deliveries until the queue is closed
+func (wh *WebhookHandler) work() {
+	defer wh.workers.Done()
+	for delivery := range wh.jobs {
+		wh.deliver(delivery)
+
+		wh.mu.Lock()
+		delete(wh.inflight, delivery.DeliveryID)
+		wh.mu.Unlock()
+	}
+}
+
+// deliver claims a delivery and makes an attempt at sending it to its webhook. The delivery is skipped if it has
+// been claimed or attempted since it was queued. If there are already MaxPerHost requests to the host of the
+// webhook, the delivery is released for a later poll
+func (wh *WebhookHandler) deliver(queued *mdb.Delivery) {
+	now := util.NowMilli()
+	delivery, err := wh.db.ClaimDelivery(queued, now, wh.leaseUntil(now))
+	if err == mdb.ErrNotFound {
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	webhook, err := wh.db.GetWebhook(delivery.WebhookID.Hex())
+	if err == mdb.ErrNotFound {
+		wh.attempt(delivery, nil)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		wh.release(delivery)
+		return
+	}
+
+	host := webhook.WebhookURL
+	if u, err := url.Parse(webhook.WebhookURL); err == nil {
+		host = u.Host
+	}
+	if !wh.acquireHost(host) {
+		wh.release(delivery)
+		return
+	}
+	defer wh.releaseHost(host)
+
+	wh.attempt(delivery, webhook)
+}
+
+// acquireHost reserves one of the concurrent requests to a host, returns false if they are all in use
+func (wh *WebhookHandler) acquireHost(host string) bool {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if wh.hosts[host] >= wh.policy.MaxPerHost {
+		return false
+	}
+	wh.hosts[host]++
+	return true
+}
+
+func (wh *WebhookHandler) releaseHost(host string) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if wh.hosts[host]--; wh.hosts[host] <= 0 {
+		delete(wh.hosts, host)
+	}
+}
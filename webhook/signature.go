@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/mdb"
+)
+
+const (
+	// SignatureHeader is the header of webhook deliveries containing the timestamp and signatures of the delivery
+	// The value is "t=<unix timestamp>,v1=<signature>", with one v1 signature for each valid secret of the webhook
+	SignatureHeader = "X-Paragliding-Signature"
+	// DeliveryHeader is the header of webhook deliveries containing the unique ID of the delivery
+	DeliveryHeader = "X-Paragliding-Delivery"
+	// SignatureTolerance is how old the timestamp of a delivery may be before VerifySignature rejects it
+	SignatureTolerance = 5 * time.Minute
+
+	// minSecretLength and maxSecretLength limit the length of secrets chosen by clients
+	minSecretLength = 16
+	maxSecretLength = 256
+)
+
+// Errors returned by VerifySignature
+var (
+	ErrInvalidSignatureHeader = errors.New("invalid signature header")
+	ErrSignatureExpired       = errors.New("signature timestamp is outside the tolerance")
+	ErrSignatureMismatch      = errors.New("no signature matches the secret")
+)
+
+// NewSecret generates a random secret for signing webhook deliveries
+func NewSecret() (string, error) {
+	return randomHex(32)
+}
+
+// newDeliveryID generates a random ID for a webhook delivery
+func newDeliveryID() (string, error) {
+	return randomHex(16)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign computes the hex encoded HMAC-SHA256 signature of a delivery. The signed message is the timestamp, the
+// delivery ID and the body separated by dots, so that the timestamp and ID can not be changed without the secret
+func Sign(secret string, timestamp int64, deliveryID string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + deliveryID + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signatureHeader returns the value of SignatureHeader for a delivery to the webhook. While the previous secret
+// is still in its grace period, the delivery is also signed with it
+func signatureHeader(webhook *mdb.Webhook, now time.Time, deliveryID string, body []byte) string {
+	ts := now.Unix()
+	header := "t=" + strconv.FormatInt(ts, 10) + ",v1=" + Sign(webhook.Secret, ts, deliveryID, body)
+	if webhook.PreviousSecret != "" && webhook.PreviousSecretExpires > now.UnixNano()/int64(time.Millisecond) {
+		header += ",v1=" + Sign(webhook.PreviousSecret, ts, deliveryID, body)
+	}
+	return header
+}
+
+// VerifySignature checks the signature header of a delivery received by a webhook, this is what receivers must do
+// to know that the delivery was sent by this server. Receivers should also remember the delivery IDs they have
+// seen within SignatureTolerance, and reject deliveries with IDs they have already seen
+func VerifySignature(header string, deliveryID string, body []byte, secret string, now time.Time) error {
+	var ts int64 = -1
+	signatures := make([]string, 0)
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return ErrInvalidSignatureHeader
+		}
+		switch kv[0] {
+		case "t":
+			t, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return ErrInvalidSignatureHeader
+			}
+			ts = t
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	if ts < 0 || len(signatures) == 0 {
+		return ErrInvalidSignatureHeader
+	}
+
+	if age := now.Sub(time.Unix(ts, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return ErrSignatureExpired
+	}
+
+	expected := []byte(Sign(secret, ts, deliveryID, body))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), expected) {
+			return nil
+		}
+	}
+	return ErrSignatureMismatch
+}
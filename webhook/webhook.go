@@ -1,75 +1,96 @@
 package webhook
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/haakonleg/imt2681-assig2/mdb"
 	"github.com/haakonleg/imt2681-assig2/router"
-	"github.com/haakonleg/imt2681-assig2/ticker"
+	"github.com/haakonleg/imt2681-assig2/track"
 	"github.com/haakonleg/imt2681-assig2/util"
-	"github.com/mongodb/mongo-go-driver/bson"
-	"github.com/mongodb/mongo-go-driver/bson/objectid"
 )
 
-type WebhookHandler struct {
-	db *mdb.Database
+// The error codes used when a webhook is registered without an URL, with a negative minTriggerValue, with a secret
+// that is too short or long, or with an invalid filter
+const (
+	CodeMissingWebhookURL = "missing_webhook_url"
+	CodeInvalidMinTrigger = "invalid_min_trigger_value"
+	CodeInvalidSecret     = "invalid_secret"
+	CodeInvalidFilter     = "invalid_filter"
+)
+
+// PostWebhookRequest is the body of POST /api/webhook/new_track
+// If Secret is not set, a random secret is generated. If Filter is set, only the matching tracks count toward the
+// trigger and are sent to the webhook
+type PostWebhookRequest struct {
+	WebhookURL      string            `json:"webhookURL"`
+	MinTriggerValue int64             `json:"minTriggerValue"`
+	Secret          string            `json:"secret,omitempty"`
+	Filter          mdb.WebhookFilter `json:"filter"`
 }
 
-func NewWebhookHandler(db *mdb.Database) *WebhookHandler {
-	return &WebhookHandler{
-		db: db}
+// PostWebhookResponse is the response to POST /api/webhook/new_track, it is the only time the secret is sent
+type PostWebhookResponse struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
 }
 
-// CheckInvokeWebhooks decrements the trigger counters of each webhook by one, then checks which webhooks that have their counter/trigger
-// equal to zero and invokes the ones who have, then their counter is reset
-func (wh *WebhookHandler) CheckInvokeWebhooks(db *mdb.Database) {
-	// Decrement all webhooks triggercount by one
-	updateDoc := bson.NewDocument(
-		bson.EC.SubDocumentFromElements("$inc",
-			bson.EC.Int64("triggerCount", -1)))
-	wh.db.Update(mdb.WEBHOOKS, nil, updateDoc)
-
-	// Retrieve all webhooks where the counter is zero
-	filter := bson.NewDocument(
-		bson.EC.SubDocumentFromElements("triggerCount",
-			bson.EC.Int64("$eq", 0)))
-
-	webhooks := make([]*mdb.Webhook, 0)
-	wh.db.Find(mdb.WEBHOOKS, filter, nil, &webhooks)
-
-	// Invoke the webhooks
-	for _, webhook := range webhooks {
-		invokeWebhook(webhook, wh.db)
-
-		// Reset the invoked webhook counter and set lastInvoked
-		filter = bson.NewDocument(bson.EC.ObjectID("_id", webhook.ID))
-		updateDoc = bson.NewDocument(
-			bson.EC.SubDocumentFromElements("$set",
-				bson.EC.Int64("triggerCount", webhook.MinTriggerValue),
-				bson.EC.Int64("lastInvoked", util.NowMilli())))
-		db.Update(mdb.WEBHOOKS, filter, updateDoc)
-	}
+// WebhookHandler contains the handlers of the webhook API, and the dispatcher that sends deliveries to the
+// webhooks, see Start
+type WebhookHandler struct {
+	db     mdb.Storage
+	policy DeliveryPolicy
+	client *http.Client
+
+	events    chan track.TrackRegistered
+	jobs      chan *mdb.Delivery
+	stop      chan struct{}
+	producers sync.WaitGroup
+	workers   sync.WaitGroup
+	shutdown  sync.Once
+	done      chan struct{}
+
+	mu       sync.Mutex
+	inflight map[string]bool
+	hosts    map[string]int
 }
 
-// invokeWebhook sends a POST request to the webhook containing information about added tracks
-func invokeWebhook(webhook *mdb.Webhook, db *mdb.Database) {
-	// Build the request
-	var request []byte
-	ticker, er := ticker.MakeTicker(db, 0, webhook.LastInvoked)
-	if er != nil {
-		request, _ = json.Marshal(er)
-	} else {
-		request, _ = json.Marshal(ticker)
-	}
+func NewWebhookHandler(db mdb.Storage) *WebhookHandler {
+	return &WebhookHandler{
+		db:     db,
+		policy: DefaultDeliveryPolicy}
+}
 
-	_, err := http.Post(webhook.WebhookURL, "application/json", bytes.NewBuffer(request))
-	if err != nil {
+// checkInvokeWebhooks decrements the trigger counters of each webhook whose filter matches the registered track by
+// one, then invokes each webhook whose counter has reached zero. The counters are decremented and fired atomically
+// by the storage backend, so each time a counter reaches zero the webhook is invoked exactly once, even if tracks are
+// registered concurrently
+func (wh *WebhookHandler) checkInvokeWebhooks(track *mdb.Track) {
+	// Decrement the triggercount of the matching webhooks by one
+	if err := wh.db.DecrementWebhookTriggers(track); err != nil {
 		fmt.Println(err)
+		return
+	}
+
+	// Invoke the webhooks where the counter reached zero, until there are none left
+	for {
+		webhook, err := wh.db.FireTriggeredWebhook(util.NowMilli())
+		if err == mdb.ErrNotFound {
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		delivery, err := wh.enqueue(webhook)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		wh.dispatch(delivery)
 	}
-	fmt.Printf("Invoked webhook %s", webhook.WebhookURL)
 }
 
 // GetWebhook is the handler for the API path GET /api/webhook/new_track/{webhook_id}
@@ -78,24 +99,17 @@ func (wh *WebhookHandler) GetWebhook(req *router.Request) {
 	webhookID := req.Vars["id"].(string)
 
 	// Retrieve webhook from DB
-	objectID, err := objectid.FromHex(webhookID)
-	if err != nil {
-		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Message: "Invalid ID"})
-		return
-	}
-	filter := bson.NewDocument(bson.EC.ObjectID("_id", objectID))
-
-	webhooks := make([]*mdb.Webhook, 0)
-	if err := wh.db.Find(mdb.WEBHOOKS, filter, nil, &webhooks); err != nil {
-		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Message: "Internal database error"})
+	webhook, err := wh.db.GetWebhook(webhookID)
+	if err == mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidID, Message: "Invalid ID"})
 		return
 	}
-	if len(webhooks) < 1 {
-		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Message: "Invalid ID"})
+	if err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
 		return
 	}
 
-	req.SendJSON(&webhooks[0], http.StatusOK)
+	req.Respond(webhook, http.StatusOK, router.JSON, router.MSGPACK)
 }
 
 // DeleteWebhook is the handler for the API path DELETE /api/webhook/new_track/{webhook_id}
@@ -104,20 +118,13 @@ func (wh *WebhookHandler) DeleteWebhook(req *router.Request) {
 	webhookID := req.Vars["id"].(string)
 
 	// Delete webhook from DB
-	objectID, err := objectid.FromHex(webhookID)
-	if err != nil {
-		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Message: "Invalid ID"})
+	err := wh.db.DeleteWebhook(webhookID)
+	if err == mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidID, Message: "Invalid ID"})
 		return
 	}
-	filter := bson.NewDocument(bson.EC.ObjectID("_id", objectID))
-
-	delRes, err := wh.db.Delete(mdb.WEBHOOKS, filter)
 	if err != nil {
-		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Message: "Internal database error"})
-		return
-	}
-	if delRes.DeletedCount == 0 {
-		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Message: "Invalid ID"})
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
 		return
 	}
 
@@ -126,22 +133,53 @@ func (wh *WebhookHandler) DeleteWebhook(req *router.Request) {
 
 // Register a webhook to be notified when new tracks are created
 func (wh *WebhookHandler) PostWebhook(req *router.Request) {
-	var webhookReq mdb.Webhook
-	err := req.ParseJSONRequest(&webhookReq)
-	if err != nil || len(webhookReq.WebhookURL) == 0 {
-		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Message: "Invalid JSON"})
+	var webhookReq PostWebhookRequest
+	if err := req.ParseJSONRequest(&webhookReq); err != nil {
+		if jErr, ok := err.(*router.JSONError); ok {
+			req.SendError(jErr.RouterError())
+			return
+		}
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidJSON, Message: "Invalid JSON"})
+		return
+	}
+	if len(webhookReq.WebhookURL) == 0 {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: CodeMissingWebhookURL, Message: "Missing webhookURL"})
+		return
+	}
+	if webhookReq.MinTriggerValue < 0 {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidMinTrigger, Message: "minTriggerValue can not be negative"})
+		return
+	}
+
+	secret := webhookReq.Secret
+	if secret == "" {
+		var err error
+		if secret, err = NewSecret(); err != nil {
+			fmt.Println(err)
+			req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeInternalError, Message: "Error generating secret"})
+			return
+		}
+	} else if rErr := validateSecret(secret); rErr != nil {
+		req.SendError(rErr)
 		return
 	}
 
-	webhook := mdb.CreateWebhook(webhookReq.WebhookURL, webhookReq.MinTriggerValue)
-	id, err := wh.db.InsertObject(mdb.WEBHOOKS, &webhook)
+	if webhookReq.Filter.MinDistance < 0 {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidFilter, Message: "The minimum distance can not be negative"})
+		return
+	}
+
+	webhook := mdb.CreateWebhook(webhookReq.WebhookURL, webhookReq.MinTriggerValue, secret)
+	webhook.Filter = webhookReq.Filter.Normalize()
+	id, err := wh.db.InsertWebhook(&webhook)
 	if err != nil {
-		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Message: "Internal database error"})
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
 		return
 	}
 
-	response := struct {
-		ID string `json:"id"`
-	}{ID: id}
-	req.SendJSON(&response, http.StatusOK)
+	// Send response, with the URL to the new webhook in the Location header
+	if location, err := req.URLFor("webhook", map[string]interface{}{"id": id}); err == nil {
+		req.W.Header().Set("Location", location)
+	}
+	req.SendJSON(&PostWebhookResponse{ID: id, Secret: secret}, http.StatusOK)
 }
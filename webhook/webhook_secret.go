@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/haakonleg/imt2681-assig2/mdb"
+	"github.com/haakonleg/imt2681-assig2/router"
+	"github.com/haakonleg/imt2681-assig2/util"
+)
+
+// rotateQuery contains the query parameters of POST /api/webhook/new_track/{id}/secret
+// GracePeriod is how many seconds the previous secret is still used to sign deliveries (at most 7 days)
+type rotateQuery struct {
+	GracePeriod int64 `query:"grace_period" default:"86400" min:"0" max:"604800"`
+}
+
+// RotateSecretResponse is the response to POST /api/webhook/new_track/{id}/secret
+// PreviousSecretExpires is the timestamp (in milliseconds) when deliveries are no longer signed with the old secret
+type RotateSecretResponse struct {
+	Secret                string `json:"secret"`
+	PreviousSecretExpires int64  `json:"previous_secret_expires"`
+}
+
+// RotateWebhookSecret is the handler for the API path POST /api/webhook/new_track/{id}/secret
+// Generates a new secret for the webhook. During the grace period set with the query parameter "grace_period",
+// deliveries are signed with both the new and the old secret, so that the receiver can switch to the new secret
+func (wh *WebhookHandler) RotateWebhookSecret(req *router.Request) {
+	webhookID := req.Vars["id"].(string)
+	query := new(rotateQuery)
+	if rErr := req.BindQuery(query); rErr != nil {
+		req.SendError(rErr)
+		return
+	}
+
+	webhook, err := wh.db.GetWebhook(webhookID)
+	if err == mdb.ErrNotFound {
+		req.SendError(&router.Error{StatusCode: http.StatusBadRequest, Code: router.CodeInvalidID, Message: "Invalid ID"})
+		return
+	}
+	if err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		fmt.Println(err)
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeInternalError, Message: "Error generating secret"})
+		return
+	}
+	webhook.PreviousSecret = webhook.Secret
+	webhook.PreviousSecretExpires = util.NowMilli() + query.GracePeriod*int64(time.Second/time.Millisecond)
+	webhook.Secret = secret
+
+	if err := wh.db.UpdateWebhookSecret(webhook); err != nil {
+		req.SendError(&router.Error{StatusCode: http.StatusInternalServerError, Code: router.CodeDatabaseError, Message: "Internal database error"})
+		return
+	}
+
+	req.SendJSON(&RotateSecretResponse{Secret: secret, PreviousSecretExpires: webhook.PreviousSecretExpires}, http.StatusOK)
+}
+
+// validateSecret checks that a secret chosen by the client is long enough to be secure
+func validateSecret(secret string) *router.Error {
+	if len(secret) < minSecretLength || len(secret) > maxSecretLength {
+		return &router.Error{StatusCode: http.StatusBadRequest, Code: CodeInvalidSecret,
+			Message: fmt.Sprintf("The secret must be between %d and %d characters", minSecretLength, maxSecretLength)}
+	}
+	return nil
+}